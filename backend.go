@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/shurcooL/vcsstate"
+	"golang.org/x/tools/go/vcs"
+)
+
+// backend provides the state of repositories of a single VCS type.
+// It extends vcsstate.VCS with state that vcsstate doesn't provide.
+type backend interface {
+	vcsstate.VCS
+
+	// Type returns the VCS type, like "git" or "hg".
+	Type() string
+
+	InProgressOperation(dir string) (string, error)
+	StashList(dir string) ([]StashEntry, error)
+	CommitTime(dir string, revision string) (time.Time, error) // Empty revision means the checked out one.
+	RemoteCommitTime(dir string, revision string, defaultBranch string) (time.Time, error)
+	FetchTime(dir string) (time.Time, error)
+	Shallow(dir string) (bool, error)
+	PartialCloneFilter(dir string) (string, error)
+
+	// HeadContains is like Contains, but checks the checked out revision rather than the default branch.
+	// HeadRemoteContains is like RemoteContains, but checks remote-tracking ref remoteRef,
+	// like "refs/remotes/origin/feature", rather than that of the remote default branch.
+	HeadContains(dir string, revision string, defaultBranch string) (bool, error)
+	HeadRemoteContains(dir string, revision string, remoteRef string) (bool, error)
+
+	Submodules(dir string) ([]submoduleCheckout, error)
+	Worktrees(dir string) ([]worktreeCheckout, error)
+}
+
+// newBackend returns a backend for repositories of the VCS type of vcsCmd.
+func newBackend(vcsCmd *vcs.Cmd) (backend, error) {
+	// vcsstate doesn't support Subversion and Bazaar, so they're implemented here.
+	switch vcsCmd.Cmd {
+	case "svn":
+		return vcsBackend{VCS: svn{}, vcsType: vcsCmd.Cmd}, nil
+	case "bzr":
+		return vcsBackend{VCS: bzr{}, vcsType: vcsCmd.Cmd}, nil
+	}
+	v, err := vcsstate.NewVCS(vcsCmd)
+	if err != nil {
+		return nil, err
+	}
+	switch vcsCmd.Cmd {
+	case "hg":
+		v = hg{VCS: v}
+	case "git":
+		if *nativeGitFlag {
+			return nativeGit{vcsBackend{VCS: v, vcsType: vcsCmd.Cmd}}, nil
+		}
+	}
+	return vcsBackend{VCS: v, vcsType: vcsCmd.Cmd}, nil
+}
+
+// vcsOutput runs the VCS command name with args in dir, and returns its output without the trailing newline.
+// The error includes the command's standard error, so that it can be classified.
+func vcsOutput(dir string, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%v %v: %w: %s", name, args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+// vcsBackend is a backend for repositories on disk.
+// It uses vcsstate, and runs VCS commands for state that vcsstate doesn't provide.
+type vcsBackend struct {
+	vcsstate.VCS
+	vcsType string
+}
+
+func (b vcsBackend) Type() string { return b.vcsType }
+
+// Branch returns the checked out branch, or empty string if git HEAD is detached,
+// like it is in submodules.
+func (b vcsBackend) Branch(dir string) (string, error) {
+	branch, err := b.VCS.Branch(dir)
+	if err != nil && b.vcsType == "git" && gitDetachedHead(dir) {
+		return "", nil
+	}
+	return branch, err
+}
+
+// RemoteBranchAndRevision queries git remotes over HTTP in-process when possible,
+// so that connections are reused across repositories. See gitRemoteHead.
+// Otherwise, git remotes are queried with gitLsRemote, so that errors include
+// git's standard error for classifyUnreachable.
+func (b vcsBackend) RemoteBranchAndRevision(dir string) (branch string, revision string, err error) {
+	if b.vcsType != "git" {
+		return b.VCS.RemoteBranchAndRevision(dir)
+	}
+	remoteURL, err := b.VCS.RemoteURL(dir)
+	if err != nil {
+		return "", "", err
+	}
+	if branch, revision, err := gitRemoteHead(remoteClient, dir, remoteURL); err != errUseGit {
+		return branch, revision, err
+	}
+	return gitLsRemote(dir)
+}
+
+func (b vcsBackend) InProgressOperation(dir string) (string, error) {
+	return inProgressOperation(b.vcsType, dir)
+}
+
+func (b vcsBackend) StashList(dir string) ([]StashEntry, error) {
+	return stashList(b.vcsType, dir)
+}
+
+func (b vcsBackend) CommitTime(dir string, revision string) (time.Time, error) {
+	return commitTime(b.vcsType, dir, revision)
+}
+
+func (b vcsBackend) RemoteCommitTime(dir string, revision string, defaultBranch string) (time.Time, error) {
+	return remoteCommitTime(b.vcsType, dir, revision, defaultBranch)
+}
+
+func (b vcsBackend) FetchTime(dir string) (time.Time, error) {
+	return fetchTime(b.vcsType, dir)
+}
+
+func (b vcsBackend) Shallow(dir string) (bool, error) {
+	return shallowClone(b.vcsType, dir)
+}
+
+func (b vcsBackend) PartialCloneFilter(dir string) (string, error) {
+	return partialCloneFilter(b.vcsType, dir)
+}
+
+func (b vcsBackend) HeadContains(dir string, revision string, defaultBranch string) (bool, error) {
+	if b.vcsType != "git" {
+		return false, fmt.Errorf("HeadContains not implemented for %v", b.vcsType)
+	}
+	return gitIsAncestor(dir, revision, "HEAD")
+}
+
+func (b vcsBackend) HeadRemoteContains(dir string, revision string, remoteRef string) (bool, error) {
+	if b.vcsType != "git" {
+		return false, fmt.Errorf("HeadRemoteContains not implemented for %v", b.vcsType)
+	}
+	return gitIsAncestor(dir, revision, remoteRef)
+}
+
+func (b vcsBackend) Submodules(dir string) ([]submoduleCheckout, error) {
+	if b.vcsType != "git" {
+		// Nested repositories of other VCSes, like Mercurial subrepositories, aren't listed.
+		return nil, nil
+	}
+	return gitSubmodules(dir)
+}
+
+func (b vcsBackend) Worktrees(dir string) ([]worktreeCheckout, error) {
+	if b.vcsType != "git" {
+		// Other VCSes have no linked worktrees.
+		return nil, nil
+	}
+	return gitWorktrees(dir)
+}
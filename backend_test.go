@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// fakeBackend is a scriptable backend for tests.
+// All repositories it's used for share the same state,
+// except for submodules and worktrees, which are listed per directory.
+type fakeBackend struct {
+	status    string
+	operation string
+	branch    string
+	stash     string
+	stashes   []StashEntry
+	remoteURL string
+
+	remoteBranch   string
+	remoteRevision string
+	remoteErr      error // If non-nil, RemoteBranchAndRevision fails with it.
+
+	// cachedRemoteDefaultBranch is returned by CachedRemoteDefaultBranch.
+	// If it's empty, CachedRemoteDefaultBranch fails.
+	cachedRemoteDefaultBranch string
+
+	localRevision  string
+	contains       bool // Whether local contains remote revision.
+	remoteContains bool // Whether remote contains local revision.
+
+	commitTime         time.Time
+	remoteCommitTime   time.Time
+	fetchTime          time.Time
+	shallow            bool
+	partialCloneFilter string
+
+	submodules map[string][]submoduleCheckout // Key is directory.
+	worktrees  map[string][]worktreeCheckout  // Key is directory.
+}
+
+func (f *fakeBackend) Status(string) (string, error) { return f.status, nil }
+func (f *fakeBackend) Branch(string) (string, error) { return f.branch, nil }
+func (f *fakeBackend) LocalRevision(string, string) (string, error) {
+	return f.localRevision, nil
+}
+func (f *fakeBackend) Stash(string) (string, error) { return f.stash, nil }
+func (f *fakeBackend) Contains(string, string, string) (bool, error) {
+	return f.contains, nil
+}
+func (f *fakeBackend) RemoteContains(string, string, string) (bool, error) {
+	return f.remoteContains, nil
+}
+func (f *fakeBackend) RemoteURL(string) (string, error) { return f.remoteURL, nil }
+func (f *fakeBackend) RemoteBranchAndRevision(string) (string, string, error) {
+	if f.remoteErr != nil {
+		return "", "", f.remoteErr
+	}
+	return f.remoteBranch, f.remoteRevision, nil
+}
+func (f *fakeBackend) CachedRemoteDefaultBranch() (string, error) {
+	if f.cachedRemoteDefaultBranch == "" {
+		return "", errors.New("no cached remote default branch")
+	}
+	return f.cachedRemoteDefaultBranch, nil
+}
+func (f *fakeBackend) NoRemoteDefaultBranch() string { return "master" }
+
+func (f *fakeBackend) Type() string { return "git" }
+func (f *fakeBackend) InProgressOperation(string) (string, error) {
+	return f.operation, nil
+}
+func (f *fakeBackend) StashList(string) ([]StashEntry, error) { return f.stashes, nil }
+func (f *fakeBackend) CommitTime(string, string) (time.Time, error) {
+	return f.commitTime, nil
+}
+func (f *fakeBackend) RemoteCommitTime(string, string, string) (time.Time, error) {
+	return f.remoteCommitTime, nil
+}
+func (f *fakeBackend) FetchTime(string) (time.Time, error) { return f.fetchTime, nil }
+func (f *fakeBackend) Shallow(string) (bool, error)        { return f.shallow, nil }
+func (f *fakeBackend) PartialCloneFilter(string) (string, error) {
+	return f.partialCloneFilter, nil
+}
+func (f *fakeBackend) HeadContains(string, string, string) (bool, error) {
+	return f.contains, nil
+}
+func (f *fakeBackend) HeadRemoteContains(string, string, string) (bool, error) {
+	return f.remoteContains, nil
+}
+func (f *fakeBackend) Submodules(dir string) ([]submoduleCheckout, error) {
+	return f.submodules[dir], nil
+}
+func (f *fakeBackend) Worktrees(dir string) ([]worktreeCheckout, error) {
+	return f.worktrees[dir], nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/shurcooL/vcsstate"
+)
+
+// bzr implements vcsstate.VCS for Bazaar branches.
+//
+// Revisions are revision ids. The remote is the parent branch, and since every
+// Bazaar branch has its own location, the branch nick is reported as the remote branch.
+type bzr struct{}
+
+func (bzr) Status(dir string) (string, error) {
+	return vcsOutput(dir, "bzr", "status", "--short")
+}
+
+func (bzr) Branch(dir string) (string, error) {
+	return vcsOutput(dir, "bzr", "nick")
+}
+
+func (bzr) LocalRevision(dir string, defaultBranch string) (string, error) {
+	return bzrRevisionID(dir, ".")
+}
+
+// bzrRevisionID returns the id of the last revision of the branch at location,
+// which is relative to dir.
+func bzrRevisionID(dir, location string) (string, error) {
+	// Output is "<revno> <revision id>".
+	out, err := vcsOutput(dir, "bzr", "revision-info", "--directory", location)
+	if err != nil {
+		return "", err
+	}
+	_, id, _ := strings.Cut(out, " ")
+	return id, nil
+}
+
+func (bzr) Stash(dir string) (string, error) {
+	return vcsOutput(dir, "bzr", "shelve", "--list")
+}
+
+// Contains reports whether the branch has all revisions of its parent branch.
+// It's computed with bzr missing, so it contacts the parent branch.
+func (bzr) Contains(dir string, revision string, defaultBranch string) (bool, error) {
+	return bzrMissingNone(dir, "--theirs-only")
+}
+
+// RemoteContains reports whether the parent branch has all revisions of the branch.
+// It's computed with bzr missing, so it contacts the parent branch.
+func (bzr) RemoteContains(dir string, revision string, defaultBranch string) (bool, error) {
+	return bzrMissingNone(dir, "--mine-only")
+}
+
+// bzrMissingNone reports whether bzr missing with flag finds no missing revisions.
+func bzrMissingNone(dir, flag string) (bool, error) {
+	_, err := vcsOutput(dir, "bzr", "missing", "--line", flag)
+	var ee *exec.ExitError
+	if errors.As(err, &ee) && ee.ExitCode() == 1 {
+		// There are missing revisions.
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (bzr) RemoteURL(dir string) (string, error) {
+	info, err := vcsOutput(dir, "bzr", "info")
+	if err != nil {
+		return "", err
+	}
+	if parent := bzrInfoField(info, "parent branch"); parent != "" {
+		return parent, nil
+	}
+	return "", vcsstate.ErrNoRemote
+}
+
+// bzrInfoField returns the value of field, like "parent branch", in the output of bzr info.
+// It returns empty string if there's no such field.
+func bzrInfoField(info, field string) string {
+	for _, line := range strings.Split(info, "\n") {
+		if line := strings.TrimSpace(line); strings.HasPrefix(line, field+": ") {
+			return strings.TrimPrefix(line, field+": ")
+		}
+	}
+	return ""
+}
+
+func (b bzr) RemoteBranchAndRevision(dir string) (branch string, revision string, err error) {
+	parent, err := b.RemoteURL(dir)
+	if err != nil {
+		return "", "", err
+	}
+	revision, err = bzrRevisionID(dir, parent)
+	if err != nil && strings.Contains(err.Error(), "Not a branch") {
+		return "", "", vcsstate.NotFoundError{Err: err}
+	} else if err != nil {
+		return "", "", err
+	}
+	branch, err = b.Branch(dir)
+	if err != nil {
+		return "", "", err
+	}
+	return branch, revision, nil
+}
+
+func (bzr) CachedRemoteDefaultBranch() (string, error) {
+	return "trunk", nil
+}
+
+func (bzr) NoRemoteDefaultBranch() string {
+	return "trunk"
+}
+
+// bzrCommitTime returns the commit time of revision id rev of the branch at location,
+// which is relative to dir, or of its last revision if rev is empty.
+func bzrCommitTime(dir, location, rev string) (time.Time, error) {
+	revSpec := "-1"
+	if rev != "" {
+		revSpec = "revid:" + rev
+	}
+	out, err := vcsOutput(dir, "bzr", "log", "--limit=1", "--timezone=utc", "--revision", revSpec, location)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse("Mon 2006-01-02 15:04:05 -0700", bzrInfoField(out, "timestamp"))
+}
+
+// bzrShelves returns the shelves of the Bazaar branch containing dir, newest first.
+func bzrShelves(dir string) ([]StashEntry, error) {
+	out, err := vcsOutput(dir, "bzr", "shelve", "--list")
+	if err != nil {
+		return nil, err
+	}
+	metaDir, err := metadataDir("bzr", dir)
+	if err != nil {
+		return nil, err
+	}
+	var shelves []StashEntry
+	for _, line := range strings.Split(out, "\n") {
+		// Lines are like "  2: Shelf message".
+		id, message, ok := strings.Cut(strings.TrimSpace(line), ": ")
+		if !ok {
+			continue
+		}
+		shelf := StashEntry{Name: id, Message: message}
+		if fi, err := os.Stat(filepath.Join(metaDir, "checkout", "shelf", "shelf-"+id)); err == nil {
+			shelf.Time = fi.ModTime()
+		}
+		shelves = append(shelves, shelf)
+	}
+	return shelves, nil
+}
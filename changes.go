@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Changes is a breakdown of uncommitted changes in a working dir.
+// Each field lists the affected paths, relative to the repository root.
+type Changes struct {
+	Modified   []string `json:",omitempty"`
+	Added      []string `json:",omitempty"`
+	Deleted    []string `json:",omitempty"`
+	Renamed    []string `json:",omitempty"` // In "old -> new" form.
+	Conflicted []string `json:",omitempty"`
+	Untracked  []string `json:",omitempty"`
+}
+
+// changeKinds lists kinds of changes in the order they're presented.
+var changeKinds = []struct {
+	name  string
+	paths func(c Changes) []string
+}{
+	{"conflicted", func(c Changes) []string { return c.Conflicted }},
+	{"modified", func(c Changes) []string { return c.Modified }},
+	{"added", func(c Changes) []string { return c.Added }},
+	{"deleted", func(c Changes) []string { return c.Deleted }},
+	{"renamed", func(c Changes) []string { return c.Renamed }},
+	{"untracked", func(c Changes) []string { return c.Untracked }},
+}
+
+// Summary returns a short summary of changes, like "2 modified, 1 untracked".
+// It returns empty string if there are no changes.
+func (c Changes) Summary() string {
+	var kinds []string
+	for _, kind := range changeKinds {
+		if n := len(kind.paths(c)); n > 0 {
+			kinds = append(kinds, fmt.Sprintf("%d %s", n, kind.name))
+		}
+	}
+	return strings.Join(kinds, ", ")
+}
+
+// parseChanges parses status, the output of the status command of vcsType VCS,
+// into a breakdown of changes. Lines it doesn't recognize are skipped.
+func parseChanges(vcsType, status string) Changes {
+	var c Changes
+	for _, line := range strings.Split(status, "\n") {
+		switch vcsType {
+		case "git":
+			// Output of "git status --porcelain", e.g., " M path" or "R  old -> new".
+			if len(line) < 4 {
+				continue
+			}
+			x, y, path := line[0], line[1], line[3:]
+			switch {
+			case x == '?' && y == '?':
+				c.Untracked = append(c.Untracked, path)
+			case x == '!' && y == '!':
+				// Ignored file.
+			case x == 'U' || y == 'U' || (x == 'A' && y == 'A') || (x == 'D' && y == 'D'):
+				c.Conflicted = append(c.Conflicted, path)
+			case x == 'R':
+				c.Renamed = append(c.Renamed, path)
+			case x == 'A' || x == 'C':
+				c.Added = append(c.Added, path)
+			case x == 'D' || y == 'D':
+				c.Deleted = append(c.Deleted, path)
+			default:
+				c.Modified = append(c.Modified, path)
+			}
+		case "hg":
+			// Output of "hg status", e.g., "M path".
+			if len(line) < 3 {
+				continue
+			}
+			switch path := line[2:]; line[0] {
+			case 'M':
+				c.Modified = append(c.Modified, path)
+			case 'A':
+				c.Added = append(c.Added, path)
+			case 'R', '!':
+				c.Deleted = append(c.Deleted, path)
+			case '?':
+				c.Untracked = append(c.Untracked, path)
+			}
+		case "svn":
+			// Output of "svn status", e.g., "M       path". The first 7 columns are flags,
+			// where the first is for contents, the second for properties, and the seventh for tree conflicts.
+			if len(line) < 9 {
+				continue
+			}
+			path := line[8:]
+			switch {
+			case line[0] == 'C' || line[1] == 'C' || line[6] == 'C':
+				c.Conflicted = append(c.Conflicted, path)
+			case line[0] == '?':
+				c.Untracked = append(c.Untracked, path)
+			case line[0] == 'A':
+				c.Added = append(c.Added, path)
+			case line[0] == 'D' || line[0] == '!':
+				c.Deleted = append(c.Deleted, path)
+			case line[0] == 'M' || line[0] == 'R' || line[0] == '~' || line[1] == 'M':
+				c.Modified = append(c.Modified, path)
+			}
+		case "bzr":
+			// Output of "bzr status --short", e.g., " M  path" or "R   old => new".
+			// The first column is for versioning changes, and the second for contents changes.
+			if len(line) < 5 {
+				continue
+			}
+			path := line[4:]
+			switch x, y := line[0], line[1]; {
+			case x == '?':
+				c.Untracked = append(c.Untracked, path)
+			case x == 'C':
+				c.Conflicted = append(c.Conflicted, path)
+			case x == 'R':
+				c.Renamed = append(c.Renamed, strings.Replace(path, " => ", " -> ", 1))
+			case x == '+' || y == 'N':
+				c.Added = append(c.Added, path)
+			case x == '-' || y == 'D':
+				c.Deleted = append(c.Deleted, path)
+			case y == 'M' || y == 'K':
+				c.Modified = append(c.Modified, path)
+			}
+		}
+	}
+	return c
+}
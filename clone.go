@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// shallowClone reports whether the repository containing dir is a shallow clone,
+// i.e., its history is truncated.
+func shallowClone(vcsType, dir string) (bool, error) {
+	switch vcsType {
+	case "git":
+		out, err := gitOutput(dir, "rev-parse", "--is-shallow-repository")
+		if err != nil {
+			return false, err
+		}
+		return out == "true", nil
+	case "hg", "svn":
+		// Mercurial clones always have full history, and Subversion history is kept by the server.
+		return false, nil
+	case "bzr":
+		// Stacked branches keep only the revisions not in the branch they're stacked on.
+		info, err := vcsOutput(dir, "bzr", "info")
+		if err != nil {
+			return false, err
+		}
+		return bzrInfoField(info, "stacked on") != "", nil
+	default:
+		return false, fmt.Errorf("shallow clone detection not implemented for %v", vcsType)
+	}
+}
+
+// partialCloneFilter returns the object filter of the repository containing dir,
+// like "blob:none", if it's a partial clone. It returns empty string otherwise.
+func partialCloneFilter(vcsType, dir string) (string, error) {
+	switch vcsType {
+	case "git":
+		cmd := exec.Command("git", "config", "--get-regexp", `^remote\..*\.partialclonefilter$`)
+		cmd.Dir = dir
+		out, err := cmd.Output()
+		if ee, ok := err.(*exec.ExitError); ok && ee.ExitCode() == 1 {
+			// No matching config keys, so not a partial clone.
+			return "", nil
+		} else if err != nil {
+			return "", fmt.Errorf("git config: %v", err)
+		}
+		// Output is "<key> <filter>" lines, one per remote.
+		line, _, _ := strings.Cut(string(out), "\n")
+		_, filter, _ := strings.Cut(line, " ")
+		return filter, nil
+	case "hg", "svn", "bzr":
+		// There are no partial clones.
+		return "", nil
+	default:
+		return "", fmt.Errorf("partial clone detection not implemented for %v", vcsType)
+	}
+}
+
+// incompleteClone returns "shallow" or "partial" if r is a clone with incomplete history,
+// and empty string otherwise.
+func incompleteClone(r *Repo) string {
+	switch {
+	case r.Local.Shallow:
+		return "shallow"
+	case r.Local.PartialCloneFilter != "":
+		return "partial"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// localCheckout returns the directory of the local checkout of module m required by
+// the main module in directory modDir, if there's one. That's its replacement directory
+// if it's replaced by one, or otherwise its directory in GOPATH if it's checked out there.
+func localCheckout(modDir string, gomod *goModFile, m moduleVersion) (string, bool) {
+	if r, ok := gomod.replacement(m); ok {
+		if !r.localDir() {
+			return "", false
+		}
+		if filepath.IsAbs(r.Path) {
+			return r.Path, true
+		}
+		return filepath.Join(modDir, filepath.FromSlash(r.Path)), true
+	}
+	for _, gopath := range filepath.SplitList(build.Default.GOPATH) {
+		dir := filepath.Join(gopath, "src", filepath.FromSlash(m.Path))
+		if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+			return dir, true
+		}
+		// A major version module may be checked out on a major branch,
+		// rather than in a major version subdirectory.
+		if i := strings.LastIndex(m.Path, "/v"); i != -1 && isMajorSuffix(m.Path[i+1:]) {
+			dir := filepath.Join(gopath, "src", filepath.FromSlash(m.Path[:i]))
+			if f, err := readGoMod(filepath.Join(dir, "go.mod")); err == nil && f.Module == m.Path {
+				return dir, true
+			}
+		}
+	}
+	return "", false
+}
+
+// isMajorSuffix reports whether s is a major version suffix of a module path, like "v2".
+func isMajorSuffix(s string) bool {
+	if len(s) < 2 || s[0] != 'v' || s[1] == '0' || s == "v1" {
+		return false
+	}
+	for _, c := range s[1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// checkoutDrift returns descriptions of the local checkouts of the requirements
+// of the main module in directory modDir whose contents differ from what its
+// go.sum pins, i.e., whose module hash at the required version doesn't match.
+// Requirements without a go.sum hash can't be checked, so they're skipped.
+// So are checkouts in repositories that don't have the required version checked out,
+// since their contents are expected to differ. Only git checkouts' versions are known,
+// so checkouts in repositories of other VCSes are skipped too.
+func checkoutDrift(modDir string) ([]string, error) {
+	gomod, err := readGoMod(filepath.Join(modDir, "go.mod"))
+	if err != nil {
+		return nil, err
+	}
+	sums, err := readGoSum(filepath.Join(modDir, "go.sum"))
+	if err != nil {
+		return nil, err
+	}
+	var drift []string
+	for _, req := range gomod.Require {
+		sum, ok := sums[req]
+		if !ok {
+			continue
+		}
+		dir, ok := localCheckout(modDir, gomod, req)
+		if !ok {
+			continue
+		}
+		if _, err := os.Stat(dir); err != nil {
+			// A missing replacement directory has no contents to check.
+			continue
+		}
+		if root, vcsType, ok := repoRootDir(dir); ok {
+			if vcsType != "git" {
+				continue
+			}
+			if at, err := gitCheckedOutAt(root, dir, req); err != nil {
+				return drift, fmt.Errorf("%v: %v", req, err)
+			} else if !at {
+				continue
+			}
+		}
+		mf, err := checkoutModuleFiles(dir)
+		if err != nil {
+			return drift, fmt.Errorf("%v: %v", req, err)
+		}
+		if got, err := mf.hash1(req); err != nil {
+			return drift, fmt.Errorf("%v: %v", req, err)
+		} else if got != sum {
+			drift = append(drift, fmt.Sprintf("%v in %s", req, dir))
+		}
+	}
+	return drift, nil
+}
+
+// gitCheckedOutAt reports whether the git repository at root has the commit of version
+// m.Version checked out, where dir is the directory of module m in it. Tags of modules
+// in subdirectories are prefixed by the subdirectory, without a major version suffix.
+// It reports false if the commit isn't in the repository.
+func gitCheckedOutAt(root, dir string, m moduleVersion) (bool, error) {
+	rev, ok := pseudoVersionRevision(m.Version)
+	if !ok {
+		rel, err := filepath.Rel(root, dir)
+		if err != nil {
+			return false, err
+		}
+		rel = filepath.ToSlash(rel)
+		if base := path.Base(rel); isMajorSuffix(base) && strings.HasSuffix(m.Path, "/"+base) {
+			// Major version subdirectory, like "v2" of "example.com/dep/v2".
+			rel = path.Dir(rel)
+		}
+		tag := strings.TrimSuffix(m.Version, "+incompatible")
+		if rel != "." {
+			tag = rel + "/" + tag
+		}
+		rev = "refs/tags/" + tag
+	}
+	commit, err := gitOutput(dir, "rev-parse", "--verify", "--quiet", rev+"^{commit}")
+	if err != nil {
+		// Not fetched, so it can't be checked out.
+		return false, nil
+	}
+	head, err := gitOutput(dir, "rev-parse", "HEAD")
+	if err != nil {
+		return false, err
+	}
+	return head == commit, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"go/build"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// newDepCheckout creates a git checkout of module example.com/dep in a temporary GOPATH,
+// with files that module zips leave out, and returns its directory.
+func newDepCheckout(t *testing.T) string {
+	setGitTestEnv(t)
+	gopath := t.TempDir()
+	old := build.Default.GOPATH
+	t.Cleanup(func() { build.Default.GOPATH = old })
+	build.Default.GOPATH = gopath
+
+	dir := filepath.Join(gopath, "src", "example.com", "dep")
+	git(t, gopath, "init", "--quiet", dir)
+	for name, content := range map[string]string{
+		".gitignore":                 "/bin/\n",
+		"go.mod":                     "module example.com/dep\n\ngo 1.19\n",
+		"dep.go":                     "package dep\n",
+		"bin/tool":                   "Ignored build output.\n",
+		"sub/go.mod":                 "module example.com/dep/sub\n",
+		"sub/sub.go":                 "package sub\n",
+		"vendor/modules.txt":         "# example.com/v v1.0.0\nexample.com/v\n",
+		"vendor/example.com/v/v.go":  "package v\n",
+		"internal/vendor/x/vendored": "Vendored.\n",
+	} {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, name, content)
+	}
+	git(t, dir, "add", ".")
+	git(t, dir, "commit", "--quiet", "--message=Initial commit.")
+	git(t, dir, "tag", "v1.0.0")
+	return dir
+}
+
+func TestCheckoutModuleFiles(t *testing.T) {
+	dir := newDepCheckout(t)
+	writeFile(t, filepath.Join(dir, "untracked.go"), "package dep\n")
+	mf, err := checkoutModuleFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{".gitignore", "dep.go", "go.mod", "vendor/modules.txt"}
+	if got := mf.names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got files %q, want %q", got, want)
+	}
+}
+
+func TestCheckoutDrift(t *testing.T) {
+	dir := newDepCheckout(t)
+	dep := moduleVersion{"example.com/dep", "v1.0.0"}
+	mf, err := checkoutModuleFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := mf.hash1(dep)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mod := t.TempDir()
+	writeFile(t, filepath.Join(mod, "go.mod"), "module example.com/main\n\ngo 1.19\n\nrequire (\n\texample.com/dep v1.0.0\n\texample.com/missing v1.0.0\n)\n")
+	writeFile(t, filepath.Join(mod, "go.sum"), "example.com/dep v1.0.0 "+sum+"\nexample.com/dep v1.0.0/go.mod h1:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=\n"+
+		"example.com/missing v1.0.0 h1:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=\n")
+
+	check := func(name string, want []string) {
+		t.Helper()
+		got, err := checkoutDrift(mod)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got drift %q, want %q", name, got, want)
+		}
+	}
+	check("pinned", nil)
+	writeFile(t, filepath.Join(dir, "bin", "tool"), "Rebuilt.\n")
+	check("ignored file changed", nil)
+	writeFile(t, filepath.Join(dir, "dep.go"), "package dep // Modified.\n")
+	check("modified", []string{"example.com/dep@v1.0.0 in " + dir})
+	git(t, dir, "commit", "--quiet", "--all", "--message=Modify.")
+	check("newer commit checked out", nil)
+	git(t, dir, "checkout", "--quiet", "v1.0.0")
+
+	// Replacement directories are checked too.
+	writeFile(t, filepath.Join(mod, "go.mod"), "module example.com/main\n\ngo 1.19\n\nrequire example.com/dep v1.0.0\n\nreplace example.com/dep => ./dep\n")
+	git(t, dir, "checkout", "--quiet", "dep.go")
+	git(t, mod, "clone", "--quiet", dir, filepath.Join(mod, "dep"))
+	check("replaced", nil)
+	writeFile(t, filepath.Join(mod, "dep", "dep.go"), "package dep // Modified.\n")
+	check("replaced and modified", []string{"example.com/dep@v1.0.0 in " + filepath.Join(mod, "dep")})
+}
+
+// TestCheckoutDriftGoSum checks a checkout against the hash that the go command
+// records in go.sum, rather than one computed by hash1 itself.
+func TestCheckoutDriftGoSum(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not found:", err)
+	}
+	setGitTestEnv(t)
+	t.Setenv("GO111MODULE", "on")
+	t.Setenv("GOFLAGS", "-modcacherw")
+	t.Setenv("GOPROXY", newModuleProxy(t))
+	t.Setenv("GOSUMDB", "off")
+	t.Setenv("GOWORK", "off")
+	t.Setenv("GOMODCACHE", t.TempDir())
+
+	gopath := t.TempDir()
+	defer func(gopath string) { build.Default.GOPATH = gopath }(build.Default.GOPATH)
+	build.Default.GOPATH = gopath
+	dir := filepath.Join(gopath, "src", "example.com", "dep")
+	git(t, gopath, "init", "--quiet", dir)
+	for name, content := range depModuleFiles {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, name, content)
+	}
+	git(t, dir, "add", ".")
+	git(t, dir, "commit", "--quiet", "--message=Initial commit.")
+	git(t, dir, "tag", "v1.0.0")
+
+	mod := t.TempDir()
+	writeFile(t, filepath.Join(mod, "go.mod"), "module example.com/main\n\ngo 1.19\n\nrequire example.com/dep v1.0.0\n")
+	writeFile(t, filepath.Join(mod, "main.go"), "package main\n\nimport _ \"example.com/dep\"\n\nfunc main() {}\n")
+	cmd := exec.Command("go", "mod", "tidy")
+	cmd.Dir = mod
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go mod tidy: %v\n%s", err, out)
+	}
+
+	if got, err := checkoutDrift(mod); err != nil || got != nil {
+		t.Errorf("pinned: got drift %q, %v; want none", got, err)
+	}
+	writeFile(t, filepath.Join(dir, "dep.go"), "package dep // Modified.\n")
+	if got, err := checkoutDrift(mod); err != nil || !reflect.DeepEqual(got, []string{"example.com/dep@v1.0.0 in " + dir}) {
+		t.Errorf("modified: got drift %q, %v; want it", got, err)
+	}
+}
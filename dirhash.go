@@ -0,0 +1,189 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// moduleFiles are the files of a module version, like those in the module cache
+// or in a module zip.
+type moduleFiles struct {
+	open  map[string]func() (io.ReadCloser, error) // Slash-separated path relative to module root -> opener.
+	close func() error
+}
+
+// dirModuleFiles returns the regular files in the tree of directory dir.
+func dirModuleFiles(dir string) (*moduleFiles, error) {
+	mf := &moduleFiles{open: make(map[string]func() (io.ReadCloser, error)), close: func() error { return nil }}
+	err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		mf.open[filepath.ToSlash(rel)] = func() (io.ReadCloser, error) { return os.Open(name) }
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mf, nil
+}
+
+// checkoutModuleFiles returns the files of the module in directory dir of a checkout,
+// with the working tree contents, that a module zip created from the checkout would contain.
+// Those are the regular files tracked by the VCS, when it's git, and otherwise all regular files,
+// except for ones in VCS metadata directories, nested modules and vendored packages.
+func checkoutModuleFiles(dir string) (*moduleFiles, error) {
+	var tracked map[string]bool
+	if _, vcsType, ok := repoRootDir(dir); ok && vcsType == "git" {
+		out, err := vcsOutput(dir, "git", "ls-files", "-z", "--cached")
+		if err != nil {
+			return nil, err
+		}
+		tracked = make(map[string]bool)
+		for _, name := range strings.Split(out, "\x00") {
+			tracked[name] = true
+		}
+	}
+	mf := &moduleFiles{open: make(map[string]func() (io.ReadCloser, error)), close: func() error { return nil }}
+	err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if name == dir {
+				return nil
+			}
+			for _, vcsDir := range vcsMetadataDirs {
+				if d.Name() == vcsDir {
+					return filepath.SkipDir
+				}
+			}
+			if fi, err := os.Lstat(filepath.Join(name, "go.mod")); err == nil && !fi.IsDir() {
+				// Nested module.
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !d.Type().IsRegular() || rel == ".hg_archival.txt" || vendoredPackageFile(rel) || (tracked != nil && !tracked[rel]) {
+			return nil
+		}
+		mf.open[rel] = func() (io.ReadCloser, error) { return os.Open(name) }
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mf, nil
+}
+
+// vendoredPackageFile reports whether the file at slash-separated path name
+// is in a vendored package, so it's left out of module zips. Like the go command,
+// it only considers the first vendor directory below the module root when the
+// name doesn't start with one, which can't be fixed without changing module hashes.
+func vendoredPackageFile(name string) bool {
+	var i int
+	if strings.HasPrefix(name, "vendor/") {
+		i += len("vendor/")
+	} else if j := strings.Index(name, "/vendor/"); j >= 0 {
+		i += len("/vendor/")
+	} else {
+		return false
+	}
+	return strings.Contains(name[i:], "/")
+}
+
+// zipModuleFiles returns the files in the module zip at name, for module m.
+// The caller must call close when done.
+func zipModuleFiles(name string, m moduleVersion) (*moduleFiles, error) {
+	z, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, err
+	}
+	mf := &moduleFiles{open: make(map[string]func() (io.ReadCloser, error)), close: z.Close}
+	prefix := m.String() + "/"
+	for _, f := range z.File {
+		if !strings.HasPrefix(f.Name, prefix) {
+			z.Close()
+			return nil, fmt.Errorf("%s: file %q isn't in module %v", name, f.Name, m)
+		}
+		if strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		mf.open[strings.TrimPrefix(f.Name, prefix)] = f.Open
+	}
+	return mf, nil
+}
+
+// names returns the sorted names of files in mf.
+func (mf *moduleFiles) names() []string {
+	var names []string
+	for name := range mf.open {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// read returns the contents of file name in mf.
+func (mf *moduleFiles) read(name string) ([]byte, error) {
+	rc, err := mf.open[name]()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// hash1 returns the "h1:" hash of the files of module m, as recorded in go.sum files.
+// It's a SHA-256 hash of a summary listing the SHA-256 hash of each file.
+func (mf *moduleFiles) hash1(m moduleVersion) (string, error) {
+	summary := sha256.New()
+	for _, name := range mf.names() {
+		full := m.String() + "/" + name
+		if strings.Contains(full, "\n") {
+			return "", fmt.Errorf("file name %q contains a newline", full)
+		}
+		rc, err := mf.open[name]()
+		if err != nil {
+			return "", err
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, rc)
+		rc.Close()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(summary, "%x  %s\n", h.Sum(nil), full)
+	}
+	return "h1:" + base64.StdEncoding.EncodeToString(summary.Sum(nil)), nil
+}
+
+// sameContents reports whether the file at name has contents b.
+func sameContents(name string, b []byte) (bool, error) {
+	got, err := os.ReadFile(name)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(got, b), nil
+}
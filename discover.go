@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// ScanDir walks the directory tree rooted at root, and adds every VCS checkout in it
+// to the workspace, including ones that contain no Go packages. Directories in GOPATH
+// that aren't in any checkout are added as orphaned, grouped by the topmost one.
+// It must be called before ImportPaths is closed.
+func (w *workspace) ScanDir(root string) {
+	var checkouts []string // Stack of checkouts that contain the directory being walked.
+	err := filepath.WalkDir(root, func(dir string, d fs.DirEntry, err error) error {
+		if err != nil {
+			w.Errors <- err
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		for _, name := range vcsMetadataDirs {
+			if d.Name() == name {
+				return filepath.SkipDir
+			}
+		}
+		for len(checkouts) > 0 && !within(checkouts[len(checkouts)-1], dir) {
+			checkouts = checkouts[:len(checkouts)-1]
+		}
+		for _, name := range vcsMetadataDirs {
+			if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+				w.addRepoDir(dir, vcs.ByCmd(strings.TrimPrefix(name, ".")))
+				checkouts = append(checkouts, dir)
+				return nil
+			}
+		}
+		if len(checkouts) > 0 || w.orphans == nil {
+			return nil
+		}
+		if abs, err := filepath.Abs(dir); err == nil {
+			if importPath, ok := gopathImportPath(abs); ok && !w.orphans.containsRepo(abs) {
+				w.addOrphanDir(abs, importPath)
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		w.Errors <- err
+	}
+}
+
+// addRepoDir adds the repository of VCS type vcsCmd checked out at dir,
+// unless it was already added.
+//
+// Its import path is inferred from its location if it's in GOPATH.
+// Otherwise, it's inferred from its remote URL once that's known,
+// and the directory is used until then.
+func (w *workspace) addRepoDir(dir string, vcsCmd *vcs.Cmd) {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	repo := &Repo{Path: dir}
+	if root, ok := gopathImportPath(dir); ok {
+		repo.Root = root
+	} else {
+		repo.Root = filepath.ToSlash(dir)
+		repo.rootInferred = true
+	}
+	if vcs, err := newBackend(vcsCmd); err == nil {
+		repo.vcs = vcs
+	} else {
+		repo.vcsError = fmt.Errorf("%v not supported by vcsstate: %v", vcsCmd.Name, err)
+	}
+
+	if w.roots != nil && !repo.rootInferred {
+		w.roots.add(repo.Root, dir)
+	}
+	w.addUnique(repo, dir)
+}
+
+// gopathImportPath returns the import path corresponding to directory dir,
+// and reports whether dir is in the src directory of a GOPATH workspace.
+func gopathImportPath(dir string) (string, bool) {
+	for _, gopath := range filepath.SplitList(build.Default.GOPATH) {
+		rel, err := filepath.Rel(filepath.Join(gopath, "src"), dir)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		return filepath.ToSlash(rel), true
+	}
+	return "", false
+}
+
+// importPathFromRemoteURL infers an import path from remoteURL, like "github.com/user/repo"
+// for "https://github.com/user/repo.git" or "git@github.com:user/repo.git".
+// It returns empty string if remoteURL isn't the URL of a repository on a network host.
+func importPathFromRemoteURL(remoteURL string) string {
+	var host, p string
+	if u, err := url.Parse(remoteURL); err == nil && u.Host != "" {
+		host, p = u.Hostname(), u.Path
+	} else if i := strings.Index(remoteURL, ":"); i > 1 && !strings.Contains(remoteURL, "://") && !strings.Contains(remoteURL[:i], "/") {
+		// The scp-like syntax "[user@]host:path". Single letters before the colon are Windows drive letters.
+		host, p = remoteURL[:i], remoteURL[i+1:]
+		if at := strings.LastIndex(host, "@"); at != -1 {
+			host = host[at+1:]
+		}
+	}
+	p = strings.TrimSuffix(strings.Trim(p, "/"), ".git")
+	if host == "" || p == "" {
+		return ""
+	}
+	return strings.ToLower(host) + "/" + p
+}
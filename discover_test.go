@@ -0,0 +1,97 @@
+package main
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestImportPathFromRemoteURL(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"https://github.com/user/repo.git", "github.com/user/repo"},
+		{"https://github.com/user/repo", "github.com/user/repo"},
+		{"ssh://git@GitHub.com:22/user/repo.git/", "github.com/user/repo"},
+		{"git@github.com:user/repo.git", "github.com/user/repo"},
+		{"example.com:repo", "example.com/repo"},
+		{"file:///home/user/repo.git", ""},
+		{"/home/user/repo", ""},
+		{"../repo", ""},
+		{`C:\src\repo`, ""},
+		{"", ""},
+	}
+	for _, tc := range tests {
+		if got := importPathFromRemoteURL(tc.in); got != tc.want {
+			t.Errorf("importPathFromRemoteURL(%q): got %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestScanDir(t *testing.T) {
+	tmp := t.TempDir()
+	for _, dir := range []string{
+		"gopath/src/example.com/repo/.git",
+		"gopath/src/example.com/repo/nested/.git",
+		"gopath/src/example.com/repo/nested/pkg",
+		"docs/.git",
+		"docs/.git/modules/sub/.git", // Within metadata, so it's skipped.
+		"gopath/src/example.com/leftover/pkg",
+		"gopath/src/example.com/repo/plain", // Within a checkout, so it's not orphaned.
+		"plain/dir",
+	} {
+		if err := os.MkdirAll(filepath.Join(tmp, filepath.FromSlash(dir)), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(gopath string) { build.Default.GOPATH = gopath }(build.Default.GOPATH)
+	build.Default.GOPATH = filepath.Join(tmp, "gopath")
+
+	w := &workspace{
+		unique:  make(chan *Repo, 10),
+		Errors:  make(chan error, 10),
+		repos:   make(map[string]*Repo),
+		roots:   newRepoRoots(),
+		orphans: newOrphanDirs(),
+	}
+	w.ScanDir(tmp)
+	w.ScanDir(tmp) // Repositories already added are skipped.
+	close(w.unique)
+	close(w.Errors)
+	for err := range w.Errors {
+		t.Error(err)
+	}
+
+	type repo struct {
+		Root, Path   string
+		rootInferred bool
+	}
+	var got []repo
+	for r := range w.unique {
+		got = append(got, repo{r.Root, r.Path, r.rootInferred})
+		if r.vcs == nil && r.Root != "example.com/leftover" {
+			t.Errorf("%v: no VCS backend: %v", r.Root, r.vcsError)
+		}
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i].Path < got[j].Path })
+	want := []repo{
+		{filepath.ToSlash(filepath.Join(tmp, "docs")), filepath.Join(tmp, "docs"), true},
+		{"example.com/leftover", filepath.Join(tmp, "gopath", "src", "example.com", "leftover"), false},
+		{"example.com/repo", filepath.Join(tmp, "gopath", "src", "example.com", "repo"), false},
+		{"example.com/repo/nested", filepath.Join(tmp, "gopath", "src", "example.com", "repo", "nested"), false},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d repos %v, want %d %v", len(got), got, len(want), want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("repo %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if root, ok := w.roots.lookup("example.com/repo/plain"); !ok || root != "example.com/repo" {
+		t.Errorf("roots.lookup: got %q, %v; want example.com/repo, true", root, ok)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// gitIsAncestor reports whether revision a is an ancestor of revision b.
+// Like vcsstate's Contains, it reports false if either isn't a known commit,
+// such as a remote revision that hasn't been fetched.
+func gitIsAncestor(dir, a, b string) (bool, error) {
+	for _, rev := range []string{a, b} {
+		if !gitCommitExists(dir, rev) {
+			return false, nil
+		}
+	}
+	cmd := exec.Command("git", "merge-base", "--is-ancestor", a, b)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if ee, ok := err.(*exec.ExitError); ok && ee.ExitCode() == 1 {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("git merge-base --is-ancestor: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return true, nil
+}
+
+// gitCommitExists reports whether rev is a commit in the git repository containing dir.
+func gitCommitExists(dir, rev string) bool {
+	cmd := exec.Command("git", "cat-file", "-e", rev+"^{commit}")
+	cmd.Dir = dir
+	return cmd.Run() == nil
+}
+
+// gitDetachedHead reports whether HEAD of the git repository containing dir is detached.
+func gitDetachedHead(dir string) bool {
+	cmd := exec.Command("git", "symbolic-ref", "--quiet", "HEAD")
+	cmd.Dir = dir
+	err := cmd.Run()
+	ee, ok := err.(*exec.ExitError)
+	return ok && ee.ExitCode() == 1
+}
+
+// gitOutput runs git with args in dir, and returns its output without the trailing newline.
+func gitOutput(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %v: %v", args[0], err)
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
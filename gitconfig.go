@@ -0,0 +1,177 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// gitConfig is parsed git configuration. Entries are in the order they apply,
+// so later entries override earlier ones.
+type gitConfig []gitConfigEntry
+
+type gitConfigEntry struct {
+	Section    string // Lowercase.
+	Subsection string
+	Key        string // Lowercase.
+	Value      string
+}
+
+// Get returns the value of key in section and subsection, which must be lowercase.
+func (c gitConfig) Get(section, subsection, key string) (string, bool) {
+	for i := len(c) - 1; i >= 0; i-- {
+		if e := c[i]; e.Section == section && e.Subsection == subsection && e.Key == key {
+			return e.Value, true
+		}
+	}
+	return "", false
+}
+
+// RewriteURL applies url.<base>.insteadOf rules to url, like git does for remote URLs.
+// The longest matching prefix wins.
+func (c gitConfig) RewriteURL(url string) string {
+	var base, prefix string
+	for _, e := range c {
+		if e.Section == "url" && e.Key == "insteadof" && strings.HasPrefix(url, e.Value) && len(e.Value) > len(prefix) {
+			base, prefix = e.Subsection, e.Value
+		}
+	}
+	if prefix == "" {
+		return url
+	}
+	return base + strings.TrimPrefix(url, prefix)
+}
+
+// readGitConfig reads and parses the system, global and repository configuration
+// of the git repository with common directory commonDir.
+//
+// It returns errNativeUnsupported if the configuration uses features it doesn't implement,
+// like includes, or is overridden via environment variables.
+func readGitConfig(commonDir string) (gitConfig, error) {
+	for _, env := range []string{"GIT_CONFIG", "GIT_CONFIG_COUNT", "GIT_CONFIG_PARAMETERS", "GIT_CONFIG_SYSTEM"} {
+		if _, ok := os.LookupEnv(env); ok {
+			return nil, errNativeUnsupported
+		}
+	}
+	var files []string
+	if os.Getenv("GIT_CONFIG_NOSYSTEM") == "" {
+		// This is the system config location of most git builds, but not all.
+		files = append(files, "/etc/gitconfig")
+	}
+	if global, ok := os.LookupEnv("GIT_CONFIG_GLOBAL"); ok {
+		files = append(files, global)
+	} else if home, err := os.UserHomeDir(); err == nil {
+		xdg := os.Getenv("XDG_CONFIG_HOME")
+		if xdg == "" {
+			xdg = filepath.Join(home, ".config")
+		}
+		files = append(files, filepath.Join(xdg, "git", "config"), filepath.Join(home, ".gitconfig"))
+	}
+	files = append(files, filepath.Join(commonDir, "config"))
+
+	var c gitConfig
+	for _, name := range files {
+		b, err := os.ReadFile(name)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		entries, err := parseGitConfig(string(b))
+		if err != nil {
+			return nil, err
+		}
+		c = append(c, entries...)
+	}
+	return c, nil
+}
+
+// parseGitConfig parses the contents of a git config file.
+func parseGitConfig(data string) (gitConfig, error) {
+	var (
+		c                   gitConfig
+		section, subsection string
+	)
+	lines := strings.Split(data, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(line, "[") {
+			end := strings.IndexByte(line, ']')
+			if q := strings.IndexByte(line, '"'); q != -1 && q < end {
+				// The subsection is quoted, and may contain ']'.
+				end = strings.LastIndex(line, `"]`) + 1
+			}
+			if end <= 0 {
+				return nil, errNativeUnsupported
+			}
+			header := line[1:end]
+			line = strings.TrimSpace(line[end+1:])
+			if name, sub, ok := strings.Cut(header, " "); ok {
+				section, subsection = strings.ToLower(name), unquoteGitConfigValue(strings.TrimSpace(sub))
+			} else if name, sub, ok := strings.Cut(header, "."); ok {
+				// Deprecated "[section.subsection]" syntax, where subsection is case-insensitive.
+				section, subsection = strings.ToLower(name), strings.ToLower(sub)
+			} else {
+				section, subsection = strings.ToLower(header), ""
+			}
+			if section == "include" || section == "includeif" {
+				return nil, errNativeUnsupported
+			}
+		}
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			// A key without value is a boolean true.
+			c = append(c, gitConfigEntry{section, subsection, strings.ToLower(strings.TrimSpace(key)), "true"})
+			continue
+		}
+		value = strings.TrimSpace(value)
+		for strings.HasSuffix(value, `\`) && !strings.HasSuffix(value, `\\`) && i+1 < len(lines) {
+			// Line continuation.
+			i++
+			value = strings.TrimSuffix(value, `\`) + strings.TrimSpace(lines[i])
+		}
+		c = append(c, gitConfigEntry{section, subsection, strings.ToLower(strings.TrimSpace(key)), unquoteGitConfigValue(value)})
+	}
+	return c, nil
+}
+
+// unquoteGitConfigValue returns the value of raw git config value s,
+// with quotes and escape sequences interpreted and trailing comments removed.
+func unquoteGitConfigValue(s string) string {
+	var (
+		b       strings.Builder
+		quoted  bool
+		trimmed int // Length of b without trailing unquoted whitespace.
+	)
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; {
+		case ch == '"':
+			quoted = !quoted
+			continue
+		case ch == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'b':
+				b.WriteByte('\b')
+			default:
+				b.WriteByte(s[i])
+			}
+		case !quoted && (ch == '#' || ch == ';'):
+			return b.String()[:trimmed]
+		case !quoted && (ch == ' ' || ch == '\t'):
+			b.WriteByte(ch)
+			continue
+		default:
+			b.WriteByte(ch)
+		}
+		trimmed = b.Len()
+	}
+	return b.String()[:trimmed]
+}
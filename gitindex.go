@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+)
+
+// gitIndexEntry is an entry of the git index.
+type gitIndexEntry struct {
+	Mode  uint32
+	Hash  string // Hex-encoded object name.
+	Stage int    // Merge stage, 0 unless conflicted.
+	Path  string // Slash-separated path relative to the working dir root.
+}
+
+// gitlinkMode is the mode of index entries that record submodule revisions.
+const gitlinkMode = 0160000
+
+// readGitIndex reads the entries of git index file name, in index order.
+// It supports index versions 2, 3 and 4 with SHA-1 object names.
+// It returns errNativeUnsupported for split and sparse indexes,
+// whose entries aren't all in the file.
+func readGitIndex(name string) ([]gitIndexEntry, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	const (
+		headerSize = 12
+		hashSize   = 20
+		statSize   = 40 // ctime, mtime, dev, ino, mode, uid, gid and size.
+	)
+	if len(b) < headerSize+hashSize || string(b[:4]) != "DIRC" {
+		return nil, fmt.Errorf("%s: not a git index", name)
+	}
+	version := binary.BigEndian.Uint32(b[4:])
+	if version < 2 || version > 4 {
+		return nil, fmt.Errorf("%s: unsupported index version %d", name, version)
+	}
+	count := binary.BigEndian.Uint32(b[8:])
+	body := b[:len(b)-hashSize] // Trailing checksum.
+
+	entries := make([]gitIndexEntry, 0, count)
+	off := headerSize
+	var prevPath []byte
+	for i := uint32(0); i < count; i++ {
+		start := off
+		if off+statSize+hashSize+2 > len(body) {
+			return nil, fmt.Errorf("%s: truncated entry", name)
+		}
+		mode := binary.BigEndian.Uint32(body[off+24:])
+		off += statSize
+		hash := hex.EncodeToString(body[off : off+hashSize])
+		off += hashSize
+		flags := binary.BigEndian.Uint16(body[off:])
+		off += 2
+		if version >= 3 && flags&0x4000 != 0 {
+			off += 2 // Extended flags.
+		}
+		var path []byte
+		if version == 4 {
+			// The path is prefix-compressed: a varint number of bytes to remove
+			// from the end of the previous path, followed by the suffix to append.
+			strip, n := gitIndexVarint(body[off:])
+			if n == 0 || strip > len(prevPath) {
+				return nil, fmt.Errorf("%s: invalid path compression", name)
+			}
+			off += n
+			end := bytes.IndexByte(body[off:], 0)
+			if end == -1 {
+				return nil, fmt.Errorf("%s: truncated entry", name)
+			}
+			path = append(append([]byte(nil), prevPath[:len(prevPath)-strip]...), body[off:off+end]...)
+			off += end + 1
+		} else {
+			end := bytes.IndexByte(body[off:], 0)
+			if end == -1 {
+				return nil, fmt.Errorf("%s: truncated entry", name)
+			}
+			path = body[off : off+end]
+			// Entries are padded with NULs to a multiple of 8 bytes.
+			off = start + (off-start+end+8)&^7
+		}
+		prevPath = path
+		entries = append(entries, gitIndexEntry{
+			Mode:  mode,
+			Hash:  hash,
+			Stage: int(flags>>12) & 3,
+			Path:  string(path),
+		})
+	}
+	// Extensions follow the entries, each with a 4-byte signature and 4-byte size.
+	for off+8 <= len(body) {
+		switch sig := string(body[off : off+4]); sig {
+		case "link", "sdir":
+			// Split index or sparse index.
+			return nil, errNativeUnsupported
+		}
+		off += 8 + int(binary.BigEndian.Uint32(body[off+4:]))
+	}
+	return entries, nil
+}
+
+// gitIndexVarint decodes the variable-length integer at the start of b,
+// as used for path compression in index version 4.
+// It returns the number of bytes read, or 0 if b is too short.
+func gitIndexVarint(b []byte) (v int, n int) {
+	for n < len(b) {
+		c := b[n]
+		n++
+		v = v<<7 | int(c&0x7f)
+		if c&0x80 == 0 {
+			return v, n
+		}
+		v++
+	}
+	return 0, 0
+}
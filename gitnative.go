@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shurcooL/vcsstate"
+)
+
+// errNativeUnsupported is returned when a git repository uses features that
+// nativeGit doesn't implement, so the query has to be done by running git.
+var errNativeUnsupported = errors.New("not supported by native git backend")
+
+// nativeGit is a git backend that answers local queries by reading refs, packed-refs,
+// the index, reflogs and config directly, rather than running git processes.
+//
+// Remote queries, status and revision containment are done by the embedded backend,
+// which runs git. Local queries fall back to it too when they fail, including on
+// repositories using features nativeGit doesn't implement, so errors are git's.
+type nativeGit struct {
+	vcsBackend
+}
+
+func (b nativeGit) Branch(dir string) (string, error) {
+	if branch, err := nativeGitBranch(dir); err == nil {
+		return branch, nil
+	}
+	return b.vcsBackend.Branch(dir)
+}
+
+func (b nativeGit) LocalRevision(dir string, defaultBranch string) (string, error) {
+	if rev, err := nativeGitResolve(dir, "refs/heads/"+defaultBranch); err == nil {
+		return rev, nil
+	}
+	return b.vcsBackend.LocalRevision(dir, defaultBranch)
+}
+
+func (b nativeGit) Stash(dir string) (string, error) {
+	if stash, err := nativeGitStash(dir); err == nil {
+		return stash, nil
+	}
+	return b.vcsBackend.Stash(dir)
+}
+
+func (b nativeGit) StashList(dir string) ([]StashEntry, error) {
+	if stashes, err := nativeGitStashList(dir); err == nil {
+		return stashes, nil
+	}
+	return b.vcsBackend.StashList(dir)
+}
+
+func (b nativeGit) RemoteURL(dir string) (string, error) {
+	if url, err := nativeGitRemoteURL(dir); err == nil || err == vcsstate.ErrNoRemote {
+		return url, err
+	}
+	return b.vcsBackend.RemoteURL(dir)
+}
+
+func (b nativeGit) InProgressOperation(dir string) (string, error) {
+	if g, err := openGitRepo(dir); err == nil {
+		return operationIn("git", g.gitDir), nil
+	}
+	return b.vcsBackend.InProgressOperation(dir)
+}
+
+func (b nativeGit) FetchTime(dir string) (time.Time, error) {
+	if g, err := openGitRepo(dir); err == nil {
+		return gitFetchTime(g.commonDir)
+	}
+	return b.vcsBackend.FetchTime(dir)
+}
+
+func (b nativeGit) Shallow(dir string) (bool, error) {
+	if g, err := openGitRepo(dir); err == nil {
+		fi, err := os.Stat(filepath.Join(g.commonDir, "shallow"))
+		return err == nil && fi.Size() > 0, nil
+	}
+	return b.vcsBackend.Shallow(dir)
+}
+
+func (b nativeGit) PartialCloneFilter(dir string) (string, error) {
+	if g, err := openGitRepo(dir); err == nil {
+		for _, e := range g.config {
+			if e.Section == "remote" && e.Key == "partialclonefilter" {
+				return e.Value, nil
+			}
+		}
+		return "", nil
+	}
+	return b.vcsBackend.PartialCloneFilter(dir)
+}
+
+func (b nativeGit) Submodules(dir string) ([]submoduleCheckout, error) {
+	if subs, err := nativeGitSubmodules(dir); err == nil {
+		return subs, nil
+	}
+	return b.vcsBackend.Submodules(dir)
+}
+
+// gitRepo is the on-disk layout of a git repository.
+type gitRepo struct {
+	top       string // Root of the working dir.
+	gitDir    string // Metadata directory of the worktree, like ".git".
+	commonDir string // Metadata directory shared by all worktrees.
+	config    gitConfig
+}
+
+// openGitRepo finds the git repository containing dir, and reads its config.
+// It returns errNativeUnsupported for repositories in formats that nativeGit doesn't read,
+// and when the environment overrides the repository location.
+func openGitRepo(dir string) (*gitRepo, error) {
+	for _, env := range []string{"GIT_DIR", "GIT_WORK_TREE", "GIT_COMMON_DIR", "GIT_INDEX_FILE"} {
+		if _, ok := os.LookupEnv(env); ok {
+			return nil, errNativeUnsupported
+		}
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		dotGit := filepath.Join(dir, ".git")
+		fi, err := os.Stat(dotGit)
+		if err == nil && fi.IsDir() {
+			return newGitRepo(dir, dotGit)
+		} else if err == nil {
+			// A file pointing to the metadata directory, used by linked worktrees and submodules.
+			b, err := os.ReadFile(dotGit)
+			if err != nil {
+				return nil, err
+			}
+			gitDir := strings.TrimSpace(string(b))
+			if !strings.HasPrefix(gitDir, "gitdir: ") {
+				return nil, fmt.Errorf("%s: invalid gitfile", dotGit)
+			}
+			gitDir = strings.TrimPrefix(gitDir, "gitdir: ")
+			if !filepath.IsAbs(gitDir) {
+				gitDir = filepath.Join(dir, gitDir)
+			}
+			return newGitRepo(dir, gitDir)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, fmt.Errorf("not a git repository")
+		}
+		dir = parent
+	}
+}
+
+func newGitRepo(top, gitDir string) (*gitRepo, error) {
+	g := &gitRepo{top: top, gitDir: gitDir, commonDir: gitDir}
+	if b, err := os.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		g.commonDir = filepath.Join(gitDir, strings.TrimSpace(string(b)))
+	}
+	var err error
+	g.config, err = readGitConfig(g.commonDir)
+	if err != nil {
+		return nil, err
+	}
+	// Repository format extensions, like SHA-256 object names or the reftable ref storage,
+	// change the on-disk layout.
+	if _, ok := g.config.Get("extensions", "", "objectformat"); ok {
+		return nil, errNativeUnsupported
+	}
+	if _, ok := g.config.Get("extensions", "", "refstorage"); ok {
+		return nil, errNativeUnsupported
+	}
+	return g, nil
+}
+
+// readRef returns the contents of ref name, either a revision
+// or "ref: <target>" for symbolic refs, from loose refs or packed-refs.
+func (g *gitRepo) readRef(name string) (string, error) {
+	refsDir := g.commonDir
+	if name == "HEAD" || strings.HasPrefix(name, "refs/bisect/") || strings.HasPrefix(name, "refs/worktree/") {
+		// Per-worktree refs.
+		refsDir = g.gitDir
+	}
+	b, err := os.ReadFile(filepath.Join(refsDir, filepath.FromSlash(name)))
+	if err == nil {
+		return strings.TrimSpace(string(b)), nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	f, err := os.Open(filepath.Join(g.commonDir, "packed-refs"))
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("ref %v not found", name)
+	} else if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	// Lines are "<revision> <ref>", with "#" header lines and "^" lines for peeled tags.
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if rev, ref, ok := strings.Cut(s.Text(), " "); ok && ref == name && !strings.HasPrefix(rev, "#") {
+			return rev, nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("ref %v not found", name)
+}
+
+// resolve returns the revision ref name points to, following symbolic refs.
+func (g *gitRepo) resolve(name string) (string, error) {
+	for range [5]struct{}{} {
+		ref, err := g.readRef(name)
+		if err != nil {
+			return "", err
+		}
+		if !strings.HasPrefix(ref, "ref: ") {
+			return ref, nil
+		}
+		name = strings.TrimPrefix(ref, "ref: ")
+	}
+	return "", fmt.Errorf("ref %v is too deeply nested", name)
+}
+
+func nativeGitBranch(dir string) (string, error) {
+	g, err := openGitRepo(dir)
+	if err != nil {
+		return "", err
+	}
+	head, err := g.readRef("HEAD")
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(head, "ref: refs/heads/") {
+		// Detached HEAD, which is left for vcsstate to report.
+		return "", errNativeUnsupported
+	}
+	return strings.TrimPrefix(head, "ref: refs/heads/"), nil
+}
+
+func nativeGitResolve(dir, ref string) (string, error) {
+	g, err := openGitRepo(dir)
+	if err != nil {
+		return "", err
+	}
+	return g.resolve(ref)
+}
+
+// nativeGitStash returns the stash list in the format of git stash list.
+func nativeGitStash(dir string) (string, error) {
+	reflog, err := nativeGitStashReflog(dir)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for i, e := range reflog {
+		fmt.Fprintf(&b, "stash@{%d}: %s\n", i, e.Subject)
+	}
+	return b.String(), nil
+}
+
+func nativeGitStashList(dir string) ([]StashEntry, error) {
+	reflog, err := nativeGitStashReflog(dir)
+	if err != nil {
+		return nil, err
+	}
+	var stashes []StashEntry
+	for i, e := range reflog {
+		branch, message := parseGitStashSubject(e.Subject)
+		stashes = append(stashes, StashEntry{
+			Name:    fmt.Sprintf("stash@{%d}", i),
+			Message: message,
+			Branch:  branch,
+			Time:    e.Time,
+		})
+	}
+	return stashes, nil
+}
+
+// gitReflogEntry is an entry of a git reflog.
+type gitReflogEntry struct {
+	Time    time.Time
+	Subject string
+}
+
+// nativeGitStashReflog returns the reflog of the stash, newest first.
+func nativeGitStashReflog(dir string) ([]gitReflogEntry, error) {
+	g, err := openGitRepo(dir)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(filepath.Join(g.commonDir, "logs", "refs", "stash"))
+	if os.IsNotExist(err) {
+		if _, err := g.readRef("refs/stash"); err == nil {
+			// The stash exists, but its reflog doesn't.
+			return nil, errNativeUnsupported
+		}
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	// Lines are "<old> <new> <name> <<email>> <unix time> <timezone>\t<subject>", oldest first.
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	reflog := make([]gitReflogEntry, 0, len(lines))
+	for i := len(lines) - 1; i >= 0; i-- {
+		ident, subject, ok := strings.Cut(lines[i], "\t")
+		fields := strings.Fields(ident)
+		if !ok || len(fields) < 2 {
+			return nil, fmt.Errorf("invalid stash reflog line %q", lines[i])
+		}
+		unix, err := strconv.ParseInt(fields[len(fields)-2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stash reflog line %q: %v", lines[i], err)
+		}
+		reflog = append(reflog, gitReflogEntry{Time: time.Unix(unix, 0), Subject: subject})
+	}
+	return reflog, nil
+}
+
+func nativeGitRemoteURL(dir string) (string, error) {
+	g, err := openGitRepo(dir)
+	if err != nil {
+		return "", err
+	}
+	url, ok := g.config.Get("remote", "origin", "url")
+	if !ok {
+		return "", vcsstate.ErrNoRemote
+	}
+	return g.config.RewriteURL(url), nil
+}
+
+func nativeGitSubmodules(dir string) ([]submoduleCheckout, error) {
+	g, err := openGitRepo(dir)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := gitSubmodulePaths(g.top)
+	if err != nil || len(paths) == 0 {
+		return nil, err
+	}
+	configured := make(map[string]bool)
+	for _, p := range paths {
+		configured[p] = true
+	}
+	entries, err := readGitIndex(filepath.Join(g.gitDir, "index"))
+	if err != nil {
+		return nil, err
+	}
+	var subs []submoduleCheckout
+	for _, e := range entries {
+		if e.Mode != gitlinkMode || !configured[e.Path] {
+			continue
+		}
+		sub := submoduleCheckout{
+			Dir:       filepath.Join(g.top, filepath.FromSlash(e.Path)),
+			Path:      e.Path,
+			Submodule: Submodule{RecordedRevision: e.Hash},
+		}
+		if _, err := os.Stat(filepath.Join(sub.Dir, ".git")); err == nil {
+			sub.Initialized = true
+			sub.Revision, err = nativeGitResolve(sub.Dir, "HEAD")
+			if err != nil {
+				return nil, err
+			}
+		}
+		subs = append(subs, sub)
+	}
+	return subs, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/shurcooL/vcsstate"
+	"golang.org/x/tools/go/vcs"
+)
+
+// gitQueries are the local queries that nativeGit answers in-process.
+var gitQueries = []struct {
+	name  string
+	query func(b backend, dir string) (interface{}, error)
+}{
+	{"Branch", func(b backend, dir string) (interface{}, error) { return b.Branch(dir) }},
+	{"LocalRevision", func(b backend, dir string) (interface{}, error) { return b.LocalRevision(dir, "master") }},
+	{"Stash", func(b backend, dir string) (interface{}, error) { return b.Stash(dir) }},
+	{"StashList", func(b backend, dir string) (interface{}, error) { return b.StashList(dir) }},
+	{"RemoteURL", func(b backend, dir string) (interface{}, error) { return b.RemoteURL(dir) }},
+	{"InProgressOperation", func(b backend, dir string) (interface{}, error) { return b.InProgressOperation(dir) }},
+	{"FetchTime", func(b backend, dir string) (interface{}, error) { return b.FetchTime(dir) }},
+	{"Shallow", func(b backend, dir string) (interface{}, error) { return b.Shallow(dir) }},
+	{"PartialCloneFilter", func(b backend, dir string) (interface{}, error) { return b.PartialCloneFilter(dir) }},
+	{"Submodules", func(b backend, dir string) (interface{}, error) { return b.Submodules(dir) }},
+}
+
+func TestNativeGit(t *testing.T) {
+	dir := newGitFixture(t)
+	execGit, nativeGit := newGitBackends(t)
+	for _, q := range gitQueries {
+		want, err := q.query(execGit, dir)
+		if err != nil {
+			t.Fatalf("%s: exec: %v", q.name, err)
+		}
+		got, err := q.query(nativeGit, dir)
+		if err != nil {
+			t.Fatalf("%s: native: %v", q.name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: native got %#v, exec got %#v", q.name, got, want)
+		}
+	}
+}
+
+func BenchmarkGitBackend(b *testing.B) {
+	dir := newGitFixture(b)
+	execGit, nativeGit := newGitBackends(b)
+	for _, bb := range []struct {
+		name    string
+		backend backend
+	}{
+		{"exec", execGit},
+		{"native", nativeGit},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, q := range gitQueries {
+					if _, err := q.query(bb.backend, dir); err != nil {
+						b.Fatalf("%s: %v", q.name, err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func newGitBackends(t testing.TB) (execGit, native backend) {
+	v, err := vcsstate.NewVCS(vcs.ByCmd("git"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := vcsBackend{VCS: v, vcsType: "git"}
+	return b, nativeGit{b}
+}
+
+// setGitTestEnv skips the test if git isn't available, and isolates git
+// from user configuration, with fixed identity and dates for commits.
+func setGitTestEnv(t testing.TB) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found:", err)
+	}
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_AUTHOR_NAME", "gostatus")
+	t.Setenv("GIT_AUTHOR_EMAIL", "gostatus@example.com")
+	t.Setenv("GIT_AUTHOR_DATE", "2020-01-01T00:00:00Z")
+	t.Setenv("GIT_COMMITTER_NAME", "gostatus")
+	t.Setenv("GIT_COMMITTER_EMAIL", "gostatus@example.com")
+	t.Setenv("GIT_COMMITTER_DATE", "2020-01-01T00:00:00Z")
+}
+
+// newGitFixture creates a git repository with packed and loose refs, a remote URL
+// rewritten by insteadOf, a submodule, stash entries, a fetch and a merge in progress,
+// and returns its directory.
+func newGitFixture(t testing.TB) string {
+	setGitTestEnv(t)
+
+	tmp := t.TempDir()
+	sub := filepath.Join(tmp, "sub")
+	git(t, tmp, "init", "--quiet", "--initial-branch=master", sub)
+	writeFile(t, filepath.Join(sub, "sub.go"), "package sub\n")
+	git(t, sub, "add", ".")
+	git(t, sub, "commit", "--quiet", "--message=Initial commit.")
+
+	dir := filepath.Join(tmp, "repo")
+	git(t, tmp, "init", "--quiet", "--initial-branch=master", dir)
+	writeFile(t, filepath.Join(dir, "main.go"), "package repo\n")
+	git(t, dir, "add", ".")
+	git(t, dir, "commit", "--quiet", "--message=Initial commit.")
+	git(t, dir, "branch", "feature")
+	git(t, dir, "pack-refs", "--all")
+	git(t, dir, "-c", "protocol.file.allow=always", "submodule", "--quiet", "add", sub, "sub")
+	git(t, dir, "commit", "--quiet", "--message=Add submodule.")
+	git(t, dir, "config", "url.https://example.com/.insteadOf", "ex:")
+	git(t, dir, "remote", "add", "origin", "ex:user/repo")
+	git(t, dir, "config", "remote.origin.partialCloneFilter", "blob:none")
+	writeFile(t, filepath.Join(dir, "main.go"), "package repo // First.\n")
+	git(t, dir, "stash", "--quiet")
+	writeFile(t, filepath.Join(dir, "main.go"), "package repo // Second.\n")
+	git(t, dir, "stash", "push", "--quiet", "--message=Second")
+	writeFile(t, filepath.Join(dir, ".git", "FETCH_HEAD"), "")
+	writeFile(t, filepath.Join(dir, ".git", "MERGE_HEAD"), "0000000000000000000000000000000000000000\n")
+	return dir
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/build"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// moduleVersion is a module path and version, like "example.com/mod v1.2.3".
+// Version is empty for modules replaced by local directories, whose Path is the directory.
+type moduleVersion struct {
+	Path    string
+	Version string
+}
+
+func (m moduleVersion) String() string {
+	if m.Version == "" {
+		return m.Path
+	}
+	return m.Path + "@" + m.Version
+}
+
+// localDir reports whether m is a replacement by a local directory.
+func (m moduleVersion) localDir() bool {
+	return m.Version == "" && (strings.HasPrefix(m.Path, "./") || strings.HasPrefix(m.Path, "../") || filepath.IsAbs(m.Path))
+}
+
+// goModReplace is a replace directive in a go.mod file. Old.Version is empty
+// if all versions of Old are replaced.
+type goModReplace struct {
+	Old, New moduleVersion
+}
+
+// goModFile is the part of a go.mod file needed to check the module's dependencies.
+type goModFile struct {
+	Module  string
+	Require []moduleVersion
+	Replace []goModReplace
+}
+
+// readGoMod reads and parses the go.mod file at name.
+func readGoMod(name string) (*goModFile, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := parseGoMod(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return f, nil
+}
+
+// parseGoMod parses the module, require and replace directives of a go.mod file.
+// Other directives are skipped.
+func parseGoMod(data string) (*goModFile, error) {
+	var f goModFile
+	var block string // Verb of the block being parsed, if any.
+	for i, line := range strings.Split(data, "\n") {
+		if j := strings.Index(line, "//"); j != -1 {
+			line = line[:j]
+		}
+		fields, err := goModFields(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		verb := block
+		switch {
+		case block != "" && fields[0] == ")":
+			block = ""
+			continue
+		case block == "" && len(fields) == 2 && fields[1] == "(":
+			block = fields[0]
+			continue
+		case block == "":
+			verb, fields = fields[0], fields[1:]
+		}
+		switch verb {
+		case "module":
+			if len(fields) != 1 {
+				return nil, fmt.Errorf("line %d: usage: module module/path", i+1)
+			}
+			f.Module = fields[0]
+		case "require":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: usage: require module/path v1.2.3", i+1)
+			}
+			f.Require = append(f.Require, moduleVersion{fields[0], fields[1]})
+		case "replace":
+			arrow := -1
+			for j, field := range fields {
+				if field == "=>" {
+					arrow = j
+				}
+			}
+			if arrow < 1 || arrow > 2 || len(fields)-arrow-1 < 1 || len(fields)-arrow-1 > 2 {
+				return nil, fmt.Errorf("line %d: usage: replace module/path [v1.2.3] => other/module v1.4 or local/directory", i+1)
+			}
+			var r goModReplace
+			r.Old.Path = fields[0]
+			if arrow == 2 {
+				r.Old.Version = fields[1]
+			}
+			r.New.Path = fields[arrow+1]
+			if len(fields) == arrow+3 {
+				r.New.Version = fields[arrow+2]
+			}
+			f.Replace = append(f.Replace, r)
+		}
+	}
+	return &f, nil
+}
+
+// goModFields splits a go.mod line into fields, unquoting quoted ones.
+func goModFields(line string) ([]string, error) {
+	var fields []string
+	for line = strings.TrimSpace(line); line != ""; line = strings.TrimLeftFunc(line, unicode.IsSpace) {
+		if line[0] == '"' || line[0] == '`' {
+			n, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				return nil, err
+			}
+			field, err := strconv.Unquote(n)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, field)
+			line = line[len(n):]
+			continue
+		}
+		n := strings.IndexFunc(line, unicode.IsSpace)
+		if n == -1 {
+			n = len(line)
+		}
+		fields = append(fields, line[:n])
+		line = line[n:]
+	}
+	return fields, nil
+}
+
+// replacement returns the replacement of module m by the replace directives of f,
+// and reports whether it's replaced.
+func (f *goModFile) replacement(m moduleVersion) (moduleVersion, bool) {
+	var r *goModReplace
+	for i := range f.Replace {
+		// A replacement of a specific version takes precedence over one of all versions.
+		if f.Replace[i].Old.Path == m.Path && (f.Replace[i].Old.Version == m.Version || f.Replace[i].Old.Version == "" && r == nil) {
+			r = &f.Replace[i]
+		}
+	}
+	if r == nil {
+		return moduleVersion{}, false
+	}
+	return r.New, true
+}
+
+// readGoSum reads the go.sum file at name, and returns the h1 hashes of module contents
+// by module. Hashes of go.mod files are skipped. A missing go.sum file has no hashes.
+func readGoSum(name string) (map[moduleVersion]string, error) {
+	b, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	sums := make(map[moduleVersion]string)
+	for i, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: malformed line", name, i+1)
+		}
+		if strings.HasSuffix(fields[1], "/go.mod") {
+			continue
+		}
+		sums[moduleVersion{fields[0], fields[1]}] = fields[2]
+	}
+	return sums, nil
+}
+
+// moduleCacheDir returns the directory where the module cache keeps
+// the extracted files of module m.
+func moduleCacheDir(m moduleVersion) (string, error) {
+	cache := os.Getenv("GOMODCACHE")
+	if cache == "" {
+		gopath := filepath.SplitList(build.Default.GOPATH)
+		if len(gopath) == 0 || gopath[0] == "" {
+			return "", fmt.Errorf("module cache not found: GOMODCACHE and GOPATH are not set")
+		}
+		cache = filepath.Join(gopath[0], "pkg", "mod")
+	}
+	path, err := escapeModulePath(m.Path)
+	if err != nil {
+		return "", err
+	}
+	version, err := escapeModulePath(m.Version)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cache, filepath.FromSlash(path)+"@"+version), nil
+}
+
+// escapeModulePath escapes s for use in the module cache and module proxy protocol,
+// where each upper-case letter is replaced by "!" followed by its lower-case version.
+func escapeModulePath(s string) (string, error) {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '!' || r >= unicode.MaxASCII:
+			return "", fmt.Errorf("invalid character %q in module path or version %q", r, s)
+		case 'A' <= r && r <= 'Z':
+			b.WriteByte('!')
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String(), nil
+}
+
+// vendoredModule is a module listed in vendor/modules.txt.
+type vendoredModule struct {
+	moduleVersion
+	Replacement *moduleVersion // Replacement, if the module is replaced.
+	Explicit    bool           // Whether it's marked as explicitly required in go.mod.
+	Packages    []string       // Import paths of vendored packages.
+}
+
+// readModulesTxt reads and parses the vendor/modules.txt file at name.
+func readModulesTxt(name string) ([]*vendoredModule, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var mods []*vendoredModule
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		switch text := sc.Text(); {
+		case strings.HasPrefix(text, "## "):
+			if len(mods) == 0 {
+				return nil, fmt.Errorf("%s:%d: annotation before module", name, line)
+			}
+			for _, a := range strings.Split(strings.TrimPrefix(text, "## "), ";") {
+				if strings.TrimSpace(a) == "explicit" {
+					mods[len(mods)-1].Explicit = true
+				}
+			}
+		case strings.HasPrefix(text, "# "):
+			fields := strings.Fields(strings.TrimPrefix(text, "# "))
+			m := new(vendoredModule)
+			if i := indexOf(fields, "=>"); i != -1 {
+				old, new := fields[:i], fields[i+1:]
+				if len(old) < 1 || len(old) > 2 || len(new) < 1 || len(new) > 2 {
+					return nil, fmt.Errorf("%s:%d: malformed module line", name, line)
+				}
+				m.Path = old[0]
+				if len(old) == 2 {
+					m.Version = old[1]
+				}
+				m.Replacement = &moduleVersion{Path: new[0]}
+				if len(new) == 2 {
+					m.Replacement.Version = new[1]
+				}
+			} else if len(fields) == 2 {
+				m.Path, m.Version = fields[0], fields[1]
+			} else {
+				return nil, fmt.Errorf("%s:%d: malformed module line", name, line)
+			}
+			mods = append(mods, m)
+		case text == "" || strings.HasPrefix(text, "#"):
+			// Comments, like those of future annotations.
+		default:
+			if len(mods) == 0 {
+				return nil, fmt.Errorf("%s:%d: package before module", name, line)
+			}
+			mods[len(mods)-1].Packages = append(mods[len(mods)-1].Packages, text)
+		}
+	}
+	return mods, sc.Err()
+}
+
+// indexOf returns the index of the first s in ss, or -1 if there's none.
+func indexOf(ss []string, s string) int {
+	for i := range ss {
+		if ss[i] == s {
+			return i
+		}
+	}
+	return -1
+}
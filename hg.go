@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/shurcooL/vcsstate"
+)
+
+// hg extends vcsstate's Mercurial support with bookmarks and shelves.
+type hg struct {
+	vcsstate.VCS
+}
+
+// Branch returns the active bookmark if there is one, and the named branch otherwise.
+// The "@" bookmark is the conventional default, so it's reported as the named branch.
+func (h hg) Branch(dir string) (string, error) {
+	bookmark, err := vcsOutput(dir, "hg", "log", "--rev", ".", "--template", "{activebookmark}")
+	if err != nil {
+		return "", err
+	}
+	if bookmark != "" && bookmark != "@" {
+		return bookmark, nil
+	}
+	return h.VCS.Branch(dir)
+}
+
+// Stash returns the list of shelves, which are the Mercurial equivalent of git stash.
+func (hg) Stash(dir string) (string, error) {
+	return hgShelveList(dir)
+}
+
+// hgShelveList returns the output of "hg shelve --list". The shelve extension
+// is enabled for the command, since older Mercurial versions don't enable it by default.
+func hgShelveList(dir string) (string, error) {
+	return vcsOutput(dir, "hg", "--config", "extensions.shelve=", "shelve", "--list")
+}
+
+// hgShelves returns the shelves of the Mercurial repository containing dir, newest first.
+func hgShelves(dir string) ([]StashEntry, error) {
+	out, err := hgShelveList(dir)
+	if err != nil {
+		return nil, err
+	}
+	metaDir, err := metadataDir("hg", dir)
+	if err != nil {
+		return nil, err
+	}
+	var shelves []StashEntry
+	for _, line := range strings.Split(out, "\n") {
+		// Lines are like "name    (3d ago)    changes to: Commit message".
+		name, rest, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		shelf := StashEntry{Name: name}
+		if _, message, ok := strings.Cut(rest, ")"); ok {
+			shelf.Message = strings.TrimSpace(message)
+		}
+		// The listed age is relative and rounded, so use the time the shelf was written instead.
+		if fi, err := os.Stat(filepath.Join(metaDir, "shelved", name+".patch")); err == nil {
+			shelf.Time = fi.ModTime()
+		}
+		shelves = append(shelves, shelf)
+	}
+	return shelves, nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"go/build"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// TestWorkspace drives the workspace pipeline over a temporary GOPATH
+// containing git repositories whose remotes are local bare repositories.
+func TestWorkspace(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test that runs git in short mode")
+	}
+	setGitTestEnv(t)
+
+	tmp := t.TempDir()
+	gopath := filepath.Join(tmp, "gopath")
+	t.Setenv("GO111MODULE", "off")
+	defer func(gopath string) { build.Default.GOPATH = gopath }(build.Default.GOPATH)
+	build.Default.GOPATH = gopath
+
+	// newRepo creates a Go package at import path example.com/name, with its first commit
+	// pushed to a bare remote repository at file://tmp/remotes/name.git, and returns its directory.
+	newRepo := func(name string) string {
+		dir := filepath.Join(gopath, "src", "example.com", name)
+		remote := filepath.Join(tmp, "remotes", name+".git")
+		git(t, tmp, "init", "--quiet", "--bare", "--initial-branch=master", remote)
+		git(t, tmp, "init", "--quiet", "--initial-branch=master", dir)
+		writeFile(t, filepath.Join(dir, "main.go"), "package "+name+"\n")
+		git(t, dir, "add", ".")
+		git(t, dir, "commit", "--quiet", "--message=Initial commit.")
+		git(t, dir, "remote", "add", "origin", "file://"+filepath.ToSlash(remote))
+		git(t, dir, "push", "--quiet", "--set-upstream", "origin", "master")
+		return dir
+	}
+	// pushFromOtherClone pushes a new commit to the remote of repository name from another clone.
+	pushFromOtherClone := func(name string) {
+		other := filepath.Join(tmp, "other", name)
+		git(t, tmp, "clone", "--quiet", "file://"+filepath.ToSlash(filepath.Join(tmp, "remotes", name+".git")), other)
+		writeFile(t, filepath.Join(other, "other.go"), "package "+name+"\n")
+		git(t, other, "add", ".")
+		git(t, other, "commit", "--quiet", "--message=Commit from other clone.")
+		git(t, other, "push", "--quiet", "origin", "master")
+	}
+	// commitLocally commits a new file to repository in dir without pushing it.
+	commitLocally := func(dir string) {
+		writeFile(t, filepath.Join(dir, "local.go"), "package "+filepath.Base(dir)+"\n")
+		git(t, dir, "add", ".")
+		git(t, dir, "commit", "--quiet", "--message=Local commit.")
+	}
+
+	tests := []struct {
+		name  string
+		setup func(dir string)
+		want  []string // Acceptable compact status columns.
+	}{
+		{
+			name:  "uptodate",
+			setup: func(string) {},
+			want:  []string{"    "},
+		},
+		{
+			name:  "behind",
+			setup: func(string) { pushFromOtherClone("behind") },
+			want:  []string{"  + "},
+		},
+		{
+			name:  "ahead",
+			setup: func(dir string) { commitLocally(dir) },
+			want:  []string{"  - "},
+		},
+		{
+			name: "diverged",
+			setup: func(dir string) {
+				pushFromOtherClone("diverged")
+				commitLocally(dir)
+				// Fetch so that the remote revision is known locally.
+				git(t, dir, "fetch", "--quiet", "origin")
+			},
+			want: []string{"  ± "},
+		},
+		{
+			name:  "dirty",
+			setup: func(dir string) { writeFile(t, filepath.Join(dir, "main.go"), "package dirty // Modified.\n") },
+			want:  []string{" *  "},
+		},
+		{
+			name: "stash",
+			setup: func(dir string) {
+				writeFile(t, filepath.Join(dir, "main.go"), "package stash // Stashed.\n")
+				git(t, dir, "stash", "--quiet")
+			},
+			want: []string{"   $"},
+		},
+		{
+			name:  "branch",
+			setup: func(dir string) { git(t, dir, "checkout", "--quiet", "-b", "feature") },
+			want:  []string{"b   "},
+		},
+		{
+			name: "deletedremote",
+			setup: func(string) {
+				if err := os.RemoveAll(filepath.Join(tmp, "remotes", "deletedremote.git")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []string{"  / "},
+		},
+		{
+			name:  "noremote",
+			setup: func(dir string) { git(t, dir, "remote", "remove", "origin") },
+			want:  []string{"  ! "},
+		},
+	}
+	for _, tc := range tests {
+		tc.setup(newRepo(tc.name))
+	}
+
+	w := NewWorkspace(func(*Repo) bool { return true }, CompactPresenter)
+	w.repoRootForImportPath = func(importPath string, _ bool) (*vcs.RepoRoot, error) {
+		// Remote URLs are file paths, so there is nothing to infer from import path.
+		return &vcs.RepoRoot{Repo: "file://" + filepath.ToSlash(filepath.Join(tmp, "remotes", strings.TrimPrefix(importPath, "example.com/")+".git")), Root: importPath}, nil
+	}
+	go func() {
+		for _, tc := range tests {
+			w.ImportPaths <- "example.com/" + tc.name
+		}
+		close(w.ImportPaths)
+	}()
+	got := make(map[string]string) // Import path -> compact status columns.
+	for w.Statuses != nil || w.Errors != nil {
+		select {
+		case status, ok := <-w.Statuses:
+			if !ok {
+				w.Statuses = nil
+				continue
+			}
+			columns, importPath, _ := strings.Cut(status, " example.com/")
+			got["example.com/"+strings.TrimSuffix(importPath, "/...")] = columns
+		case err, ok := <-w.Errors:
+			if !ok {
+				w.Errors = nil
+				continue
+			}
+			t.Error(err)
+		}
+	}
+
+	for _, tc := range tests {
+		importPath := "example.com/" + tc.name
+		status, ok := got[importPath]
+		if !ok {
+			t.Errorf("%s: no status", importPath)
+			continue
+		}
+		var match bool
+		for _, want := range tc.want {
+			match = match || status == want
+		}
+		if !match {
+			t.Errorf("%s: got status %q, want %q", importPath, status, tc.want)
+		}
+	}
+}
+
+func git(t testing.TB, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+}
+
+func writeFile(t testing.TB, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestListPackagesGOPATH expands patterns in a GOPATH workspace without a main module,
+// with GO111MODULE at its default value, like gostatus always did.
+func TestListPackagesGOPATH(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not found:", err)
+	}
+	setGitTestEnv(t)
+	for _, env := range []string{"GO111MODULE", "GOFLAGS", "GOWORK"} {
+		t.Setenv(env, "")
+		os.Unsetenv(env)
+	}
+	gopath := t.TempDir()
+	t.Setenv("GOPATH", gopath)
+	defer func(gopath string) { build.Default.GOPATH = gopath }(build.Default.GOPATH)
+	build.Default.GOPATH = gopath
+	dir := filepath.Join(gopath, "src", "example.com", "repo")
+	git(t, gopath, "init", "--quiet", dir)
+	writeFile(t, filepath.Join(dir, "repo.go"), "package repo\n\nimport _ \"fmt\"\n")
+	defer func(dir string) { wd = dir }(wd)
+	wd = dir
+
+	for _, patterns := range [][]string{{"all"}, nil} {
+		w := &workspace{
+			ImportPaths: make(chan string, 1000),
+			unique:      make(chan *Repo, 10),
+			Errors:      make(chan error, 10),
+			repos:       make(map[string]*Repo),
+			listed:      make(map[string]*listedPackage),
+		}
+		if err := w.ListPackages(patterns); err != nil {
+			t.Errorf("%v: %v", patterns, err)
+			continue
+		}
+		close(w.ImportPaths)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		w.uniqueWorker(&wg)
+		w.sendModuleRepos()
+		close(w.unique)
+		close(w.Errors)
+		for err := range w.Errors {
+			t.Errorf("%v: %v", patterns, err)
+		}
+		var roots []string
+		for r := range w.unique {
+			roots = append(roots, r.Root)
+		}
+		if len(roots) != 1 || roots[0] != "example.com/repo" {
+			t.Errorf("%v: got repos %v, want example.com/repo", patterns, roots)
+		}
+	}
+}
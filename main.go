@@ -2,29 +2,34 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-
-	"github.com/kisielk/gotool"
 )
 
 // parallelism for workers.
 const parallelism = 8
 
 var (
-	debugFlag   = flag.Bool("debug", false, "Cause the repository data to be printed in verbose debug format.")
-	fFlag       = flag.Bool("f", false, "Force not to verify that each package has been checked out from the source control repository implied by its import path. This can be useful if the source is a local fork of the original.")
-	stdinFlag   = flag.Bool("stdin", false, "Read the list of newline separated Go packages from stdin.")
-	vFlag       = flag.Bool("v", false, "Verbose mode. Show all Go packages, not just ones with notable status.")
-	compactFlag = flag.Bool("c", false, "Compact output with inline notation.")
+	debugFlag      = flag.Bool("debug", false, "Cause the repository data to be printed in verbose debug format.")
+	fFlag          = flag.Bool("f", false, "Force not to verify that each package has been checked out from the source control repository implied by its import path. This can be useful if the source is a local fork of the original.")
+	dirFlag        = flag.String("dir", "", "Scan the directory tree for VCS checkouts, including ones without Go packages, and for directories in GOPATH that are not under VCS, instead of taking packages.")
+	stdinFlag      = flag.Bool("stdin", false, "Read the list of Go packages from stdin.")
+	stdinFormat    = newStdinFormatFlag("stdin-format", "lines", "Format of the list of Go packages read with -stdin: newline separated \"lines\", NUL separated \"nul\", or \"golist-json\" for the output of go list -json.")
+	vFlag          = flag.Bool("v", false, "Verbose mode. Show all Go packages, not just ones with notable status, and list files with uncommitted changes and stash entries.")
+	compactFlag    = flag.Bool("c", false, "Compact output with inline notation.")
+	showErrorsFlag = flag.Bool("show-errors", false, "Show errors encountered while computing the state of each repository.")
+	sshMuxFlag     = flag.Bool("ssh-mux", false, "Reuse SSH connections to each host across repositories via OpenSSH connection multiplexing.")
+	nativeGitFlag  = flag.Bool("native-git", false, "Read local git state, like refs, stash and submodules, in-process instead of running git where possible.")
+	staleFlag      = newDaysFlag("stale", "Report repos whose remote has had no commits, or whose local clone hasn't been fetched, within the given duration (e.g., 90d). Only git records when a clone was fetched.")
 )
 
 func usage() {
 	fmt.Fprint(os.Stderr, "Usage: gostatus [flags] [packages]\n")
 	fmt.Fprint(os.Stderr, "       [newline separated packages] | gostatus -stdin [flags]\n")
+	fmt.Fprint(os.Stderr, "       go list -json [packages] | gostatus -stdin -stdin-format=golist-json [flags]\n")
+	fmt.Fprint(os.Stderr, "       gostatus -dir directory [flags]\n")
 	flag.PrintDefaults()
 	fmt.Fprint(os.Stderr, `
 Examples:
@@ -37,16 +42,34 @@ Examples:
   # Show status of all dependencies (recursive) of package in current dir.
   go list -deps | gostatus -stdin -v
 
+  # Same, but without locating each package again.
+  go list -json -deps | gostatus -stdin -stdin-format=golist-json -v
+
+  # Show status of all repositories under ~/src, whether they contain Go packages or not.
+  gostatus -dir ~/src
+
 Legend:
   ? - Not under version control or unreachable remote
   b - Non-default branch checked out
+  u - Submodule not initialized
+  r - Submodule revision differs from the one recorded by superproject
+  n - Nested in working tree of another repository that doesn't ignore it
+  x - Worktree directory missing but still registered, or replacement directory missing
+  m - Replacement directory declares a different module path than the one it replaces
   * - Uncommited changes in working dir
+  @ - Operation in progress (merge, rebase, cherry-pick, revert or bisect)
+  v - Vendor directory doesn't match go.mod or vendored module versions
+  p - Local checkout of dependency isn't at the pseudo-version go.mod requires
+  s - Local checkout of dependency at the required version differs from what go.sum pins
   + - Update available
   - - Local revision is ahead of remote revision
   ± - Update available; local revision is ahead of remote revision
+  % - Inconsistent revision state (e.g., force-pushed remote or replaced refs)
   ! - No remote
   / - Remote repository not found (was it deleted? made private?)
-  # - Remote path doesn't match import path
+  # - Remote URL doesn't match repo URL inferred from import path
+  ~ - Shallow or partial clone (history is incomplete)
+  z - Stale remote or local clone (see -stale)
   $ - Stash exists
 `)
 }
@@ -59,8 +82,16 @@ func main() {
 	switch {
 	default:
 		shouldShow = func(r *Repo) bool {
-			// Check for notable status.
-			return CompactPresenter(r)[:4] != "    "
+			// Check for notable status, including that of submodules, worktrees and replacements.
+			if CompactPresenter(r)[:4] != "    " {
+				return true
+			}
+			for _, nested := range nestedRepos(r) {
+				if shouldShow(nested) {
+					return true
+				}
+			}
+			return false
 		}
 	case *vFlag:
 		shouldShow = func(*Repo) bool { return true }
@@ -71,29 +102,39 @@ func main() {
 	case *debugFlag:
 		presenter = DebugPresenter
 	case *compactFlag:
-		presenter = CompactPresenter
+		presenter = WithNestedRepos(CompactPresenter)
 	default:
-		presenter = PorcelainPresenter
+		presenter = WithNestedRepos(PorcelainPresenter)
+	}
+
+	if *sshMuxFlag {
+		cleanup, err := multiplexSSH()
+		if err != nil {
+			log.Fatalln("failed to set up SSH connection multiplexing:", err)
+		}
+		defer cleanup()
 	}
 
 	workspace := NewWorkspace(shouldShow, presenter)
 
 	// Feed input into workspace processing pipeline.
-	switch *stdinFlag {
-	case false:
+	switch {
+	case *dirFlag != "":
+		go func() { // This needs to happen in the background because sending input will be blocked on processing and receiving output.
+			workspace.ScanDir(*dirFlag)
+			close(workspace.ImportPaths)
+		}()
+	case !*stdinFlag:
 		go func() { // This needs to happen in the background because sending input will be blocked on processing and receiving output.
-			importPaths := gotool.ImportPaths(flag.Args())
-			for _, importPath := range importPaths {
-				workspace.ImportPaths <- importPath
+			if err := workspace.ListPackages(flag.Args()); err != nil {
+				workspace.Errors <- err
 			}
 			close(workspace.ImportPaths)
 		}()
-	case true:
+	case *stdinFlag:
 		go func() { // This needs to happen in the background because sending input will be blocked on processing and receiving output.
-			br := bufio.NewReader(os.Stdin)
-			for line, err := br.ReadString('\n'); err == nil; line, err = br.ReadString('\n') {
-				importPath := line[:len(line)-1] // Trim last newline.
-				workspace.ImportPaths <- importPath
+			if err := workspace.ReadPackages(os.Stdin, *stdinFormat); err != nil {
+				workspace.Errors <- fmt.Errorf("reading packages from stdin: %v", err)
 			}
 			close(workspace.ImportPaths)
 		}()
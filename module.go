@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// Module is a Go module that packages belong to in module mode.
+type Module struct {
+	Path string // Module path, like "example.com/mod".
+	Dir  string // Directory holding the module's files.
+	Main bool   // Whether it's a main module, rather than a dependency.
+}
+
+// listedModule is the part of a module printed by go list -json.
+type listedModule struct {
+	Path    string
+	Dir     string
+	Main    bool
+	Replace *struct {
+		Version string // Empty for replacements by local directories.
+	}
+}
+
+// module returns the Module that m describes.
+func (m *listedModule) module() *Module {
+	return &Module{Path: m.Path, Dir: m.Dir, Main: m.Main}
+}
+
+// cached reports whether m is a dependency in the module cache, rather than
+// a main module or a dependency replaced by a local directory.
+func (m *listedModule) cached() bool {
+	return !m.Main && (m.Replace == nil || m.Replace.Version != "")
+}
+
+// goListFields are the fields of listedPackage requested from go list -json.
+const goListFields = "ImportPath,Dir,Root,Goroot,Standard,Module,Error"
+
+// ListPackages expands the package patterns, like "./..." or "all", with the go command,
+// so that they're matched like it does in both module and GOPATH mode, and sends
+// the matched packages to ImportPaths. No patterns means the package in the current directory.
+// Without a main module, patterns are matched in GOPATH mode, like gostatus always did,
+// rather than failing to find one. It must be called before ImportPaths is closed.
+func (w *workspace) ListPackages(patterns []string) error {
+	cmd := exec.Command("go", append([]string{"list", "-e", "-json=" + goListFields, "--"}, patterns...)...)
+	cmd.Dir = wd
+	if noMainModule(wd) {
+		cmd.Env = append(os.Environ(), "GO111MODULE=off")
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	err = w.ReadPackages(stdout, "golist-json")
+	if werr := cmd.Wait(); werr != nil && err == nil {
+		err = fmt.Errorf("go list: %v: %s", werr, strings.TrimSpace(stderr.String()))
+	} else if warnings := goListWarnings(stderr.String()); err == nil && warnings != "" {
+		// Warnings, like patterns that matched no packages.
+		err = fmt.Errorf("go list: %s", warnings)
+	}
+	return err
+}
+
+// noMainModule reports whether the go command has no main module in directory dir,
+// i.e., there's neither a go.mod file nor a go.work file applying to it.
+func noMainModule(dir string) bool {
+	cmd := exec.Command("go", "env", "GOMOD", "GOWORK")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		// Let go list report the problem.
+		return false
+	}
+	gomod, rest, _ := strings.Cut(string(out), "\n")
+	gowork, _, _ := strings.Cut(rest, "\n")
+	return (gomod == "" || gomod == os.DevNull) && (gowork == "" || gowork == "off")
+}
+
+// goListWarnings returns the lines of standard error of a successful go command
+// that aren't progress reports, like "go: downloading example.com/mod v1.0.0".
+func goListWarnings(stderr string) string {
+	var warnings []string
+	for _, line := range strings.Split(strings.TrimSpace(stderr), "\n") {
+		if line == "" || strings.HasPrefix(line, "go: downloading ") || strings.HasPrefix(line, "go: finding ") || strings.HasPrefix(line, "go: extracting ") {
+			continue
+		}
+		warnings = append(warnings, line)
+	}
+	return strings.Join(warnings, "\n")
+}
+
+// addModulePackage adds the repository containing the package in directory dir
+// of module mod, unless it was already added, in which case mod is recorded in it.
+// If the package isn't in a repository, its module is added as not under VCS.
+func (w *workspace) addModulePackage(dir string, mod *Module) {
+	rootDir, vcsType, ok := repoRootDir(dir)
+	if !ok {
+		w.addUnique(&Repo{Path: mod.Dir, Root: mod.Path, Modules: []*Module{mod}}, "")
+		return
+	}
+	repo := &Repo{Path: dir, Modules: []*Module{mod}}
+	if root, ok := moduleRepoRoot(mod, rootDir); ok {
+		repo.Root = root
+	} else if root, ok := gopathImportPath(rootDir); ok {
+		repo.Root = root
+	} else {
+		repo.Root = filepath.ToSlash(rootDir)
+		repo.rootInferred = true
+	}
+	vcsCmd := vcs.ByCmd(vcsType)
+	if vcs, err := newBackend(vcsCmd); err == nil {
+		repo.vcs = vcs
+	} else {
+		repo.vcsError = fmt.Errorf("%v not supported by vcsstate: %v", vcsCmd.Name, err)
+	}
+	if w.roots != nil && !repo.rootInferred {
+		w.roots.add(repo.Root, rootDir)
+	}
+	w.addUnique(repo, rootDir)
+}
+
+// addListedModule records the module of the package with import path importPath,
+// if it was listed in module mode, in the already added repository with Root root.
+// Packages of known repositories aren't located again, but may be in another module of theirs.
+func (w *workspace) addListedModule(importPath, root string) {
+	w.listedMu.Lock()
+	p := w.listed[importPath]
+	w.listedMu.Unlock()
+	if p == nil || p.Module == nil || p.Module.cached() {
+		return
+	}
+	w.reposMu.Lock()
+	if r := w.repos[root]; r != nil && r.Modules != nil {
+		r.addModule(p.Module.module())
+	}
+	w.reposMu.Unlock()
+}
+
+// sendModuleRepos sends off the repositories of modules, which addUnique holds
+// until all packages are read, so that each has all its modules. They're sent
+// in order of Root, with their modules sorted by directory.
+func (w *workspace) sendModuleRepos() {
+	w.reposMu.Lock()
+	repos := w.moduleRepos
+	w.moduleRepos = nil
+	w.reposMu.Unlock()
+	sort.Slice(repos, func(i, j int) bool { return repos[i].Root < repos[j].Root })
+	for _, r := range repos {
+		sort.Slice(r.Modules, func(i, j int) bool { return r.Modules[i].Dir < r.Modules[j].Dir })
+		w.unique <- r
+	}
+}
+
+// addModule records module mod of r, unless it's already recorded.
+func (r *Repo) addModule(mod *Module) {
+	for _, m := range r.Modules {
+		if m.Dir == mod.Dir {
+			return
+		}
+	}
+	r.Modules = append(r.Modules, mod)
+}
+
+// mainModules returns the main modules of r.
+func (r *Repo) mainModules() []*Module {
+	var mains []*Module
+	for _, m := range r.Modules {
+		if m.Main {
+			mains = append(mains, m)
+		}
+	}
+	return mains
+}
+
+// moduleRepoRoot returns the import path corresponding to the repository root
+// at directory rootDir, which contains the module mod or is contained by it.
+// It reports false if the import path can't be derived from the module path,
+// like when the module is in a directory that doesn't match its path.
+func moduleRepoRoot(mod *Module, rootDir string) (string, bool) {
+	if rel, err := filepath.Rel(mod.Dir, rootDir); err == nil && rel == "." {
+		return mod.Path, true
+	} else if err == nil && within(mod.Dir, rootDir) {
+		// Repository nested in the module.
+		return path.Join(mod.Path, filepath.ToSlash(rel)), true
+	}
+	rel, err := filepath.Rel(rootDir, mod.Dir)
+	if err != nil || !within(rootDir, mod.Dir) {
+		return "", false
+	}
+	// Module in a subdirectory of the repository, like "example.com/repo/tools"
+	// in the tools directory.
+	rel = filepath.ToSlash(rel)
+	if !strings.HasSuffix(mod.Path, "/"+rel) {
+		return "", false
+	}
+	return strings.TrimSuffix(mod.Path, "/"+rel), true
+}
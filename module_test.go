@@ -0,0 +1,144 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestModuleRepoRoot(t *testing.T) {
+	tests := []struct {
+		mod     Module
+		rootDir string
+		want    string
+		wantOK  bool
+	}{
+		{Module{Path: "example.com/repo", Dir: "/src/repo"}, "/src/repo", "example.com/repo", true},
+		{Module{Path: "example.com/repo/tools", Dir: "/src/repo/tools"}, "/src/repo", "example.com/repo", true},
+		{Module{Path: "example.com/repo/v2", Dir: "/src/repo/v2"}, "/src/repo", "example.com/repo", true},
+		{Module{Path: "example.com/repo", Dir: "/src/repo"}, "/src/repo/third_party/lib", "example.com/repo/third_party/lib", true},
+		{Module{Path: "example.com/lib", Dir: "/src/repo/tools"}, "/src/repo", "", false},
+		{Module{Path: "example.com/repo", Dir: "/src/repo"}, "/src/other", "", false},
+	}
+	for _, tc := range tests {
+		got, ok := moduleRepoRoot(&tc.mod, filepath.FromSlash(tc.rootDir))
+		if got != tc.want || ok != tc.wantOK {
+			t.Errorf("moduleRepoRoot(%+v, %q): got %q, %v; want %q, %v", tc.mod, tc.rootDir, got, ok, tc.want, tc.wantOK)
+		}
+	}
+}
+
+// TestListPackages expands patterns in a module outside GOPATH, in a repository
+// that also contains a nested module, and in a workspace of both modules.
+func TestListPackages(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not found:", err)
+	}
+	setGitTestEnv(t)
+	t.Setenv("GO111MODULE", "on")
+	t.Setenv("GOFLAGS", "-mod=mod")
+	t.Setenv("GOPROXY", "off")
+	t.Setenv("GOWORK", "off")
+
+	repo := filepath.Join(t.TempDir(), "repo")
+	git(t, filepath.Dir(repo), "init", "--quiet", repo)
+	for name, content := range map[string]string{
+		"go.mod":         "module example.com/repo\n\ngo 1.19\n",
+		"a/a.go":         "package a\n\nimport _ \"fmt\"\n",
+		"a/b/b.go":       "package b\n",
+		"tools/go.mod":   "module example.com/repo/tools\n\ngo 1.19\n",
+		"tools/tools.go": "package tools\n",
+		"go.work":        "go 1.19\n\nuse (\n\t.\n\t./tools\n)\n",
+	} {
+		name = filepath.Join(repo, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, name, content)
+	}
+	defer func(dir string) { wd = dir }(wd)
+
+	tests := []struct {
+		dir         string
+		patterns    []string
+		gowork      string
+		wantModules []string
+	}{
+		{repo, []string{"./..."}, "off", []string{"example.com/repo"}},
+		{repo, []string{"all"}, "off", []string{"example.com/repo"}}, // Includes fmt and its dependencies, which are skipped.
+		{repo, []string{"example.com/repo/a/..."}, "off", []string{"example.com/repo"}},
+		{filepath.Join(repo, "tools"), nil, "off", []string{"example.com/repo/tools"}},
+		{repo, []string{"example.com/repo/..."}, filepath.Join(repo, "go.work"), []string{"example.com/repo", "example.com/repo/tools"}},
+	}
+	for _, tc := range tests {
+		t.Setenv("GOWORK", tc.gowork)
+		if tc.gowork != "off" {
+			// Workspaces don't allow -mod=mod.
+			t.Setenv("GOFLAGS", "")
+		}
+		wd = tc.dir
+		w := &workspace{
+			ImportPaths: make(chan string, 100),
+			unique:      make(chan *Repo, 100),
+			Errors:      make(chan error, 100),
+			repos:       make(map[string]*Repo),
+			roots:       newRepoRoots(),
+			listed:      make(map[string]*listedPackage),
+		}
+		if err := w.ListPackages(tc.patterns); err != nil {
+			t.Errorf("%v: %v", tc.patterns, err)
+			continue
+		}
+		close(w.ImportPaths)
+		var wg sync.WaitGroup
+		for range [parallelism]struct{}{} {
+			wg.Add(1)
+			go w.uniqueWorker(&wg)
+		}
+		wg.Wait()
+		w.sendModuleRepos()
+		close(w.unique)
+		close(w.Errors)
+		for err := range w.Errors {
+			t.Errorf("%v: %v", tc.patterns, err)
+		}
+		var got []*Repo
+		for r := range w.unique {
+			got = append(got, r)
+		}
+		if len(got) != 1 {
+			t.Errorf("%v: got %d repos, want 1", tc.patterns, len(got))
+			continue
+		}
+		r := got[0]
+		var modules []string
+		for _, m := range r.Modules {
+			if !m.Main {
+				t.Errorf("%v: module %v isn't main", tc.patterns, m.Path)
+			}
+			modules = append(modules, m.Path)
+		}
+		if r.Root != "example.com/repo" || r.vcs == nil || strings.Join(modules, " ") != strings.Join(tc.wantModules, " ") {
+			t.Errorf("%v: got repo %q with modules %v, want example.com/repo with modules %v", tc.patterns, r.Root, modules, tc.wantModules)
+		}
+	}
+}
+
+func TestGoListWarnings(t *testing.T) {
+	tests := []struct {
+		stderr string
+		want   string
+	}{
+		{"", ""},
+		{"go: downloading example.com/mod v1.0.0\ngo: finding module for package example.com/mod/pkg\n", ""},
+		{"go: downloading example.com/mod v1.0.0\ngo: warning: \"./...\" matched no packages\n", "go: warning: \"./...\" matched no packages"},
+	}
+	for _, tc := range tests {
+		if got := goListWarnings(tc.stderr); got != tc.want {
+			t.Errorf("goListWarnings(%q): got %q, want %q", tc.stderr, got, tc.want)
+		}
+	}
+}
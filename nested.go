@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// repoRootDir returns the root directory of the innermost repository containing dir,
+// and its VCS type. It reports false if dir isn't in a repository.
+func repoRootDir(dir string) (root, vcsType string, ok bool) {
+	for {
+		for _, name := range vcsMetadataDirs {
+			if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+				return dir, strings.TrimPrefix(name, "."), true
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", "", false
+		}
+		dir = parent
+	}
+}
+
+// nestings caches which repositories are nested unignored in others, by enclosing
+// repository root directory, so that each nested repository is queried once.
+type nestings struct {
+	mu      sync.Mutex
+	results map[string]map[string]string // Enclosing root -> nested root -> result of unignoredNesting.
+}
+
+func newNestings() *nestings {
+	return &nestings{results: make(map[string]map[string]string)}
+}
+
+// unignoredNesting is like the unignoredNesting function, with results cached.
+func (n *nestings) unignoredNesting(dir string) (string, error) {
+	root, _, ok := repoRootDir(dir)
+	if !ok {
+		return "", nil
+	}
+	parent, vcsType, ok := repoRootDir(filepath.Dir(root))
+	if !ok {
+		return "", nil
+	}
+	n.mu.Lock()
+	result, ok := n.results[parent][root]
+	n.mu.Unlock()
+	if ok {
+		return result, nil
+	}
+	result, err := nestedUnignored(parent, vcsType, root)
+	if err != nil {
+		return "", err
+	}
+	n.mu.Lock()
+	if n.results[parent] == nil {
+		n.results[parent] = make(map[string]string)
+	}
+	n.results[parent][root] = result
+	n.mu.Unlock()
+	return result, nil
+}
+
+// unignoredNesting returns the root directory of the repository whose working tree
+// contains the repository at dir, if that repository neither ignores nor tracks it.
+// Such a repository shows the nested one as untracked, and may end up committing it.
+// It returns empty string otherwise.
+func unignoredNesting(dir string) (string, error) {
+	root, _, ok := repoRootDir(dir)
+	if !ok {
+		return "", nil
+	}
+	parent, vcsType, ok := repoRootDir(filepath.Dir(root))
+	if !ok {
+		return "", nil
+	}
+	return nestedUnignored(parent, vcsType, root)
+}
+
+// nestedUnignored returns parent, the root directory of a repository of type vcsType,
+// if it neither ignores nor tracks the repository at root within it.
+// It returns empty string otherwise. Only the path of root is queried,
+// rather than the status of the whole working tree.
+func nestedUnignored(parent, vcsType, root string) (string, error) {
+	rel, err := filepath.Rel(parent, root)
+	if err != nil {
+		return "", err
+	}
+	var out string
+	switch vcsType {
+	case "git":
+		rel = filepath.ToSlash(rel)
+		if ignored, err := gitPathMatches(parent, "check-ignore", "--quiet", "--", rel+"/"); err != nil || ignored {
+			return "", err
+		}
+		// Tracked as a gitlink, or by tracking files within it.
+		if tracked, err := gitPathMatches(parent, "--literal-pathspecs", "ls-files", "--error-unmatch", "--", rel); err != nil || tracked {
+			return "", err
+		}
+		return parent, nil
+	case "hg":
+		// Mercurial doesn't descend into nested repositories, so it never reports them.
+		return "", nil
+	case "svn":
+		out, err = vcsOutput(parent, "svn", "status", "--depth=empty", rel)
+	case "bzr":
+		out, err = vcsOutput(parent, "bzr", "status", "--short", rel)
+	default:
+		return "", fmt.Errorf("nested repository detection not implemented for %v", vcsType)
+	}
+	if err != nil {
+		return "", err
+	}
+	// All of them report untracked paths with a leading "?".
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "?") {
+			return parent, nil
+		}
+	}
+	return "", nil
+}
+
+// gitPathMatches runs a git command with args in dir that exits with status 1
+// when its path doesn't match, like check-ignore, and reports whether it matched.
+func gitPathMatches(dir string, args ...string) (bool, error) {
+	_, err := vcsOutput(dir, "git", args...)
+	var ee *exec.ExitError
+	if errors.As(err, &ee) && ee.ExitCode() == 1 {
+		return false, nil
+	}
+	return err == nil, err
+}
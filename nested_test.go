@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnignoredNesting(t *testing.T) {
+	setGitTestEnv(t)
+	parent := filepath.Join(t.TempDir(), "parent")
+	git(t, filepath.Dir(parent), "init", "--quiet", parent)
+	writeFile(t, filepath.Join(parent, ".gitignore"), "/ignored/\n/build/\n")
+	for _, dir := range []string{"untracked", "ignored", "tracked"} {
+		git(t, parent, "init", "--quiet", dir)
+	}
+	if err := os.Mkdir(filepath.Join(parent, "untracked", "pkg"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// A repository that's added to its parent is tracked as a gitlink.
+	git(t, filepath.Join(parent, "tracked"), "commit", "--quiet", "--allow-empty", "--message=Initial commit.")
+	git(t, parent, "-c", "advice.addEmbeddedRepo=false", "add", ".gitignore", "tracked")
+	// A repository within an ignored directory is ignored too.
+	git(t, parent, "init", "--quiet", "build/deep")
+
+	tests := []struct {
+		dir  string
+		want string
+	}{
+		{parent, ""},
+		{filepath.Join(parent, "untracked"), parent},
+		{filepath.Join(parent, "untracked", "pkg"), parent},
+		{filepath.Join(parent, "ignored"), ""},
+		{filepath.Join(parent, "build", "deep"), ""},
+		{filepath.Join(parent, "tracked"), ""},
+	}
+	cached := newNestings()
+	for _, tc := range tests {
+		for _, unignoredNesting := range []func(string) (string, error){unignoredNesting, cached.unignoredNesting, cached.unignoredNesting} {
+			got, err := unignoredNesting(tc.dir)
+			if err != nil {
+				t.Errorf("%s: %v", tc.dir, err)
+			} else if got != tc.want {
+				t.Errorf("%s: got %q, want %q", tc.dir, got, tc.want)
+			}
+		}
+	}
+}
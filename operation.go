@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// operationFiles maps VCS type to files or directories inside its metadata directory
+// whose existence indicates an operation in progress. They're checked in order.
+var operationFiles = map[string][]struct {
+	name      string // Path relative to metadata directory.
+	operation string
+}{
+	"git": {
+		{"rebase-merge", "rebase"},
+		{"rebase-apply/applying", "am"},
+		{"rebase-apply", "rebase"},
+		{"MERGE_HEAD", "merge"},
+		{"CHERRY_PICK_HEAD", "cherry-pick"},
+		{"REVERT_HEAD", "revert"},
+		{"BISECT_LOG", "bisect"},
+	},
+	"hg": {
+		{"rebasestate", "rebase"},
+		{"histedit-state", "histedit"},
+		{"graftstate", "graft"},
+		{"merge/state", "merge"},
+		{"bisect.state", "bisect"},
+	},
+	"bzr": {
+		{"checkout/rebase-state", "rebase"},
+		{"checkout/merge-hashes", "merge"},
+	},
+	// Subversion has no operations that span commands; conflicts are reported by status instead.
+	"svn": {},
+}
+
+// inProgressOperation reports the operation in progress in the repository containing dir,
+// like "merge" or "rebase", detected from the vcsType VCS metadata directory.
+// It returns empty string if there's no operation in progress.
+func inProgressOperation(vcsType, dir string) (string, error) {
+	if _, ok := operationFiles[vcsType]; !ok {
+		return "", fmt.Errorf("in-progress operation detection not implemented for %v", vcsType)
+	}
+	metaDir, err := metadataDir(vcsType, dir)
+	if err != nil {
+		return "", err
+	}
+	return operationIn(vcsType, metaDir), nil
+}
+
+// operationIn returns the operation in progress according to vcsType VCS metadata directory metaDir,
+// or empty string if there's none.
+func operationIn(vcsType, metaDir string) string {
+	for _, f := range operationFiles[vcsType] {
+		if _, err := os.Stat(filepath.Join(metaDir, filepath.FromSlash(f.name))); err == nil {
+			return f.operation
+		}
+	}
+	return ""
+}
+
+// metadataDir returns the VCS metadata directory, like ".git", of the repository containing dir.
+// For git, linked worktrees have their own metadata directory, and that is what's returned.
+func metadataDir(vcsType, dir string) (string, error) {
+	switch vcsType {
+	case "git":
+		cmd := exec.Command("git", "rev-parse", "--absolute-git-dir")
+		cmd.Dir = dir
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("git rev-parse --absolute-git-dir: %v", err)
+		}
+		return strings.TrimSuffix(string(out), "\n"), nil
+	case "hg":
+		cmd := exec.Command("hg", "root")
+		cmd.Dir = dir
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("hg root: %v", err)
+		}
+		return filepath.Join(strings.TrimSuffix(string(out), "\n"), ".hg"), nil
+	case "svn":
+		root, err := vcsOutput(dir, "svn", "info", "--show-item", "wc-root")
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(root, ".svn"), nil
+	case "bzr":
+		root, err := vcsOutput(dir, "bzr", "root")
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(root, ".bzr"), nil
+	default:
+		return "", fmt.Errorf("metadata directory lookup not implemented for %v", vcsType)
+	}
+}
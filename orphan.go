@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// orphanDirs finds orphaned directories, i.e., directories in GOPATH whose trees
+// contain no repositories, like leftover copies of packages.
+type orphanDirs struct {
+	mu      sync.Mutex
+	hasRepo map[string]bool // Directory -> whether its tree contains a repository.
+}
+
+func newOrphanDirs() *orphanDirs {
+	return &orphanDirs{hasRepo: make(map[string]bool)}
+}
+
+// top returns the topmost directory between srcRoot (exclusive) and dir (inclusive)
+// such that it and all directories below it up to dir contain no repositories.
+// dir must be in srcRoot and not be in a repository.
+func (o *orphanDirs) top(dir, srcRoot string) string {
+	top := dir
+	for d := filepath.Dir(dir); d != srcRoot && within(srcRoot, d) && !o.containsRepo(d); d = filepath.Dir(d) {
+		top = d
+	}
+	return top
+}
+
+// errFoundRepo stops walking a directory tree once a repository is found in it.
+var errFoundRepo = errors.New("found repository")
+
+// containsRepo reports whether the tree of directory dir contains a repository.
+// Unreadable directories are considered not to contain any.
+func (o *orphanDirs) containsRepo(dir string) bool {
+	o.mu.Lock()
+	has, ok := o.hasRepo[dir]
+	o.mu.Unlock()
+	if ok {
+		return has
+	}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		for _, name := range vcsMetadataDirs {
+			if d.Name() == name {
+				return errFoundRepo
+			}
+		}
+		return nil
+	})
+	has = err == errFoundRepo
+	o.mu.Lock()
+	o.hasRepo[dir] = has
+	o.mu.Unlock()
+	return has
+}
+
+// addOrphanDir adds the orphaned directory dir with import path importPath,
+// unless it was already added.
+func (w *workspace) addOrphanDir(dir, importPath string) {
+	w.addUnique(&Repo{Path: dir, Root: importPath}, "")
+}
+
+// within reports whether path is dir or is inside it.
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
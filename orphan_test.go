@@ -0,0 +1,83 @@
+package main
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestUniqueWorkerOrphans(t *testing.T) {
+	gopath := t.TempDir()
+	for _, dir := range []string{
+		"example.com/leftover/a",
+		"example.com/leftover/b/c",
+		"example.com/user/repo/.git",
+		"example.com/user/copy/pkg",
+	} {
+		if err := os.MkdirAll(filepath.Join(gopath, "src", filepath.FromSlash(dir)), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("GO111MODULE", "off")
+	defer func(gopath string) { build.Default.GOPATH = gopath }(build.Default.GOPATH)
+	build.Default.GOPATH = gopath
+
+	importPaths := []string{
+		"example.com/leftover/a",
+		"example.com/leftover/b",
+		"example.com/leftover/b/c",
+		"example.com/user/repo",
+		"example.com/user/copy/pkg",
+	}
+	w := &workspace{
+		ImportPaths: make(chan string, len(importPaths)),
+		unique:      make(chan *Repo, len(importPaths)),
+		Errors:      make(chan error, len(importPaths)),
+		repos:       make(map[string]*Repo),
+		orphans:     newOrphanDirs(),
+	}
+	for _, importPath := range importPaths {
+		w.ImportPaths <- importPath
+	}
+	close(w.ImportPaths)
+	var wg sync.WaitGroup
+	for range [parallelism]struct{}{} {
+		wg.Add(1)
+		go w.uniqueWorker(&wg)
+	}
+	wg.Wait()
+	close(w.unique)
+	close(w.Errors)
+	for err := range w.Errors {
+		t.Error(err)
+	}
+
+	got := make(map[string]string) // Root -> path of repos not under VCS.
+	for r := range w.unique {
+		if r.vcs == nil {
+			got[r.Root] = r.Path
+		}
+	}
+	want := map[string]string{
+		// The whole leftover tree is reported once.
+		"example.com/leftover": filepath.Join(gopath, "src", "example.com", "leftover"),
+		// example.com/user contains a repository, so it's not orphaned.
+		"example.com/user/copy": filepath.Join(gopath, "src", "example.com", "user", "copy"),
+	}
+	var roots []string
+	for root := range got {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+	if len(got) != len(want) {
+		t.Fatalf("got orphans %v, want %d", roots, len(want))
+	}
+	for root, path := range want {
+		if got[root] != path {
+			t.Errorf("orphan %s: got path %q, want %q", root, got[root], path)
+		}
+	}
+}
@@ -5,11 +5,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"strings"
+	"time"
 
 	"github.com/shurcooL/go/indentwriter"
 	"github.com/shurcooL/gostatus/status"
 )
 
+// now returns the current time, relative to which presenters report ages.
+// It's a variable so that tests can fix it.
+var now = time.Now
+
 // RepoFilter is a repo filter.
 type RepoFilter func(r *Repo) (show bool)
 
@@ -19,33 +25,82 @@ type RepoPresenter func(r *Repo) string
 
 // PorcelainPresenter is a simple porcelain repo presenter to humans.
 var PorcelainPresenter RepoPresenter = func(r *Repo) string {
+	if r.Submodule != nil && !r.Submodule.Initialized {
+		return CompactPresenter(r) + "\n	u Submodule not initialized"
+	}
+	if r.Worktree != nil && r.Worktree.Missing {
+		return CompactPresenter(r) + "\n	x Worktree directory is missing (see git worktree prune)"
+	}
+	if r.Replacement != nil && r.Replacement.Missing {
+		return CompactPresenter(r) + "\n	x Replacement directory is missing"
+	}
+	if r.Replacement != nil && r.Replacement.InRepo {
+		// Its state is shown for the repository it's in.
+		return CompactPresenter(r) + modulePathMismatch(r)
+	}
 	if r.vcsError != nil {
-		return CompactPresenter(r) + "\n	? Unsupported version control: " + r.vcsError.Error()
+		return CompactPresenter(r) + modulePathMismatch(r) + "\n	? Unsupported version control: " + r.vcsError.Error()
 	}
 	if r.vcs == nil {
-		// Go package not under VCS.
-		return CompactPresenter(r) + "\n	? Not under version control"
+		// Go package, orphaned directory or replacement directory not under VCS.
+		return CompactPresenter(r) + modulePathMismatch(r) + "\n	? Not under version control"
 	}
 
-	s := CompactPresenter(r)
-	if r.Local.Branch != r.Remote.Branch {
+	s := CompactPresenter(r) + modulePathMismatch(r)
+	switch {
+	case r.Submodule != nil && r.Submodule.Revision != r.Submodule.RecordedRevision:
+		s += "\n	r Submodule revision differs from the one recorded by superproject"
+	case r.Submodule == nil && r.Local.Branch != r.Remote.Branch:
 		s += "\n	b Non-default branch checked out"
 	}
+	if r.Local.NestedIn != "" {
+		s += "\n	n Nested in working tree of repository that doesn't ignore it: " + r.Local.NestedIn
+	}
+	if r.Local.Operation != "" {
+		s += "\n	@ Operation in progress: " + r.Local.Operation
+	}
 	if r.Local.Status != "" {
 		s += "\n	* Uncommited changes in working dir"
+		if summary := r.Local.Changes.Summary(); summary != "" {
+			s += " (" + summary + ")"
+		}
+		if *vFlag {
+			for _, kind := range changeKinds {
+				for _, path := range kind.paths(r.Local.Changes) {
+					s += fmt.Sprintf("\n		%-11s %s", kind.name+":", path)
+				}
+			}
+		}
+	}
+	if len(r.Local.VendorDrift) > 0 {
+		s += "\n	v Vendor directory doesn't match go.mod or vendored module versions:" +
+			"\n" + indent(strings.Join(r.Local.VendorDrift, "\n"), 2)
+	}
+	if len(r.Local.CheckoutDrift) > 0 {
+		s += "\n	s Local checkouts of dependencies differ from what go.sum pins:" +
+			"\n" + indent(strings.Join(r.Local.CheckoutDrift, "\n"), 2)
+	}
+	if len(r.Local.PseudoVersionDrift) > 0 {
+		s += "\n	p Local checkouts of dependencies aren't at the pseudo-versions go.mod requires:" +
+			"\n" + indent(strings.Join(r.Local.PseudoVersionDrift, "\n"), 2)
 	}
 	switch {
 	case r.Local.RemoteURL == "":
 		s += "\n	! No remote"
 	case r.Remote.NotFound != nil:
 		s += "\n	/ Remote repository not found (was it deleted? made private?):" +
-			"\n" + indent(r.Remote.NotFound.Error())
+			"\n" + indent(r.Remote.NotFound.Error(), 2)
 	case r.Remote.Revision == "":
-		s += "\n	? Unreachable remote (check your connection)"
+		s += "\n	? Unreachable remote (" + r.Remote.Unreachable.hint() + ")"
 	case !*fFlag && !status.EqualRepoURLs(r.Local.RemoteURL, r.Remote.RepoURL):
 		s += "\n	# Remote URL doesn't match repo URL inferred from import path:" +
 			fmt.Sprintf("\n		  (actual) %s", r.Local.RemoteURL) +
 			fmt.Sprintf("\n		(expected) %s", status.FormatRepoURL(r.Local.RemoteURL, r.Remote.RepoURL))
+	case incompleteClone(r) != "":
+		s += "\n	~ History is incomplete (" + incompleteClone(r) + " clone)"
+		if r.Local.Revision != r.Remote.Revision {
+			s += "; local revision differs from remote revision"
+		}
 	case r.Local.Revision != r.Remote.Revision:
 		switch {
 		case !r.Local.ContainsRemoteRevision && r.Remote.ContainsLocalRevision:
@@ -55,19 +110,94 @@ var PorcelainPresenter RepoPresenter = func(r *Repo) string {
 		case !r.Local.ContainsRemoteRevision && !r.Remote.ContainsLocalRevision:
 			s += "\n	± Update available; local revision is ahead of remote revision"
 		default:
-			panic(fmt.Errorf("internal error: both r.Local.ContainsRemoteRevision and r.Remote.ContainsLocalRevision are true, yet r.Local.Revision != r.Remote.Revision; this shouldn't be possible, please report if it happens"))
+			s += "\n	% Inconsistent revision state:" +
+				"\n" + indent(r.Inconsistency, 2)
 		}
 	}
+	if staleRemote(r) {
+		s += "\n	z Remote has had no commits in " + formatDays(now().Sub(r.Remote.CommitTime))
+	}
+	if staleFetch(r) {
+		s += "\n	z Local clone hasn't been fetched in " + formatDays(now().Sub(r.Local.FetchTime))
+	}
 	if r.Local.Stash != "" {
 		s += "\n	$ Stash exists"
+		switch n := len(r.Local.Stashes); {
+		case n > 0 && !r.Local.Stashes[0].Time.IsZero():
+			s += fmt.Sprintf(" (%d %s, newest %s)", n, plural(n, "entry", "entries"), formatAge(now().Sub(r.Local.Stashes[0].Time)))
+		case n > 0:
+			s += fmt.Sprintf(" (%d %s)", n, plural(n, "entry", "entries"))
+		}
+		if *vFlag {
+			for _, stash := range r.Local.Stashes {
+				s += "\n		" + stash.Name
+				var details []string
+				if !stash.Time.IsZero() {
+					details = append(details, formatAge(now().Sub(stash.Time)))
+				}
+				if stash.Branch != "" {
+					details = append(details, "on "+stash.Branch)
+				}
+				if len(details) > 0 {
+					s += " (" + strings.Join(details, ", ") + ")"
+				}
+				s += ": " + stash.Message
+			}
+		}
+	}
+	if *showErrorsFlag && len(r.Errors) > 0 {
+		s += "\n	Errors:"
+		for _, err := range r.Errors {
+			s += "\n" + indent(strings.TrimSpace(err.Error()), 2)
+		}
 	}
 	return s
 }
 
-// indent indents s by 2 tabs.
-func indent(s string) string {
+// modulePathMismatch returns a line describing how replacement directory r doesn't declare
+// the module path it replaces, or empty string if it's not a replacement or it does.
+func modulePathMismatch(r *Repo) string {
+	switch {
+	case r.Replacement == nil || !r.Replacement.modulePathMismatch():
+		return ""
+	case r.Replacement.ModulePath == "":
+		return "\n	m Replacement directory has no go.mod file declaring module path " + r.Replacement.Old.Path
+	default:
+		return "\n	m Replacement directory declares module path " + r.Replacement.ModulePath + ", not " + r.Replacement.Old.Path
+	}
+}
+
+// plural returns singular if n is 1, and plural otherwise.
+func plural(n int, singular, plural string) string {
+	if n == 1 {
+		return singular
+	}
+	return plural
+}
+
+// WithNestedRepos returns a repo presenter that presents a repo with p,
+// followed by its submodules, worktrees and replacements nested under it.
+func WithNestedRepos(p RepoPresenter) RepoPresenter {
+	var present RepoPresenter
+	present = func(r *Repo) string {
+		s := p(r)
+		for _, nested := range nestedRepos(r) {
+			s += "\n" + indent(present(nested), 1)
+		}
+		return s
+	}
+	return present
+}
+
+// nestedRepos returns submodules, worktrees and replacements of r.
+func nestedRepos(r *Repo) []*Repo {
+	return append(append(append([]*Repo(nil), r.Submodules...), r.Worktrees...), r.Replacements...)
+}
+
+// indent indents s by n tabs.
+func indent(s string, n int) string {
 	var buf bytes.Buffer
-	w := indentwriter.New(&buf, 2)
+	w := indentwriter.New(&buf, n)
 	_, err := io.WriteString(w, s)
 	if err != nil {
 		panic(fmt.Errorf("indent writes to bytes.Buffer, and should never fail, yet it did: %v", err))
@@ -77,24 +207,60 @@ func indent(s string) string {
 
 // CompactPresenter is a simple porcelain repo presenter to humans in compact form.
 var CompactPresenter RepoPresenter = func(r *Repo) string {
+	if r.Submodule != nil && !r.Submodule.Initialized {
+		return "u    " + r.Root + "/..."
+	}
+	if r.Worktree != nil && r.Worktree.Missing {
+		return "x    " + repoName(r)
+	}
+	if r.Replacement != nil && r.Replacement.Missing {
+		return "x    " + repoName(r)
+	}
+	if r.Replacement != nil && r.Replacement.InRepo {
+		// Its state is shown for the repository it's in.
+		if r.Replacement.modulePathMismatch() {
+			return "m    " + repoName(r)
+		}
+		return "     " + repoName(r)
+	}
 	if r.vcsError != nil {
-		return "???? " + r.Root + "/..."
+		return "???? " + repoName(r)
+	}
+	if r.vcs == nil && r.Replacement != nil {
+		return "???? " + repoName(r)
 	}
 	if r.vcs == nil {
-		// Go package not under VCS.
+		// Go package or orphaned directory not under VCS.
 		return "???? " + r.Root
 	}
 
 	var s string
 	switch {
+	case r.Submodule != nil && r.Submodule.Revision != r.Submodule.RecordedRevision:
+		s += "r"
+	case r.Submodule != nil:
+		// Submodules are checked out at a revision rather than a branch.
+		s += " "
+	case r.Replacement != nil && r.Replacement.modulePathMismatch():
+		s += "m"
 	case r.Local.Branch != r.Remote.Branch:
 		s += "b"
+	case r.Local.NestedIn != "":
+		s += "n"
 	default:
 		s += " "
 	}
 	switch {
+	case r.Local.Operation != "":
+		s += "@"
 	case r.Local.Status != "":
 		s += "*"
+	case len(r.Local.VendorDrift) > 0:
+		s += "v"
+	case len(r.Local.PseudoVersionDrift) > 0:
+		s += "p"
+	case len(r.Local.CheckoutDrift) > 0:
+		s += "s"
 	default:
 		s += " "
 	}
@@ -107,6 +273,8 @@ var CompactPresenter RepoPresenter = func(r *Repo) string {
 		s += "?"
 	case !*fFlag && !status.EqualRepoURLs(r.Local.RemoteURL, r.Remote.RepoURL):
 		s += "#"
+	case incompleteClone(r) != "":
+		s += "~"
 	case r.Local.Revision != r.Remote.Revision:
 		switch {
 		case !r.Local.ContainsRemoteRevision && r.Remote.ContainsLocalRevision:
@@ -116,8 +284,10 @@ var CompactPresenter RepoPresenter = func(r *Repo) string {
 		case !r.Local.ContainsRemoteRevision && !r.Remote.ContainsLocalRevision:
 			s += "±"
 		default:
-			panic(fmt.Errorf("internal error: both r.Local.ContainsRemoteRevision and r.Remote.ContainsLocalRevision are true, yet r.Local.Revision != r.Remote.Revision; this shouldn't be possible, please report if it happens"))
+			s += "%"
 		}
+	case staleRemote(r) || staleFetch(r):
+		s += "z"
 	default:
 		s += " "
 	}
@@ -127,10 +297,33 @@ var CompactPresenter RepoPresenter = func(r *Repo) string {
 	default:
 		s += " "
 	}
-	s += " " + r.Root + "/..."
+	s += " " + repoName(r)
 	return s
 }
 
+// repoName returns the name of r for presentation.
+func repoName(r *Repo) string {
+	if r.Worktree != nil {
+		// Linked worktrees share Root with their repository, so tell them apart by directory.
+		name := "worktree " + r.Path
+		switch {
+		case r.Local.Branch != "" && r.Worktree.Upstream != "":
+			name += " (" + r.Local.Branch + ", tracking " + r.Worktree.Upstream + ")"
+		case r.Local.Branch != "":
+			name += " (" + r.Local.Branch + ")"
+		}
+		return name
+	}
+	if r.Replacement != nil {
+		old := r.Replacement.Old.Path
+		if r.Replacement.Old.Version != "" {
+			old += " " + r.Replacement.Old.Version
+		}
+		return "replace " + old + " => " + r.Replacement.Dir
+	}
+	return r.Root + "/..."
+}
+
 // DebugPresenter produces verbose debug output.
 var DebugPresenter RepoPresenter = func(r *Repo) string {
 	b, err := json.MarshalIndent(r, "", "\t")
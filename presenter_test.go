@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shurcooL/vcsstate"
+)
+
+var updateFlag = flag.Bool("update", false, "Update golden files.")
+
+// testNow is the fixed current time in presenter tests.
+var testNow = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// testRepos returns a fixed corpus of repos covering all presented states.
+func testRepos() []*Repo {
+	// repo returns a repo named name that is up to date, after applying modify to it.
+	repo := func(name string, modify func(r *Repo)) *Repo {
+		r := &Repo{
+			Path: "/gopath/src/example.com/" + name,
+			Root: "example.com/" + name,
+			vcs:  &fakeBackend{},
+		}
+		r.Local.RemoteURL = "https://example.com/" + name
+		r.Local.Branch = "master"
+		r.Local.Revision = "rev1"
+		r.Local.ContainsRemoteRevision = true
+		r.Remote.RepoURL = "https://example.com/" + name
+		r.Remote.Branch = "master"
+		r.Remote.Revision = "rev1"
+		r.Remote.ContainsLocalRevision = true
+		modify(r)
+		return r
+	}
+	behind := func(r *Repo) {
+		r.Local.Revision = "rev0"
+		r.Local.ContainsRemoteRevision = false
+	}
+	return []*Repo{
+		repo("uptodate", func(*Repo) {}),
+		{Path: "/gopath/src/example.com/novcs", Root: "example.com/novcs"},
+		{Path: "/gopath/src/example.com/unsupported", Root: "example.com/unsupported", vcsError: errors.New("svn not supported by vcsstate")},
+		repo("branch", func(r *Repo) { r.Local.Branch = "feature" }),
+		repo("dirty", func(r *Repo) {
+			r.Local.Status = " M a.go\nA  b.go\n D c.go\nR  d.go -> e.go\nUU f.go\n?? g.go\n"
+			r.Local.Changes = parseChanges("git", r.Local.Status)
+		}),
+		repo("dirtysvn", func(r *Repo) {
+			r.Local.Status = "M       a.go\nA  +    b.go\nD       c.go\nC       d.go\n      C e.go\n?       f.go\n"
+			r.Local.Changes = parseChanges("svn", r.Local.Status)
+		}),
+		repo("dirtybzr", func(r *Repo) {
+			r.Local.Status = " M  a.go\n+N  b.go\n-D  c.go\nR   d.go => e.go\nC   f.go\n?   g.go\n"
+			r.Local.Changes = parseChanges("bzr", r.Local.Status)
+		}),
+		repo("nested", func(r *Repo) { r.Local.NestedIn = "/src/parent" }),
+		repo("vendordrift", func(r *Repo) {
+			r.Local.VendorDrift = []string{
+				"example.com/dep@v1.2.0 is required in go.mod, but example.com/dep@v1.1.0 is vendored",
+				"vendor/example.com/other/a.go differs from example.com/other@v0.3.0",
+			}
+		}),
+		repo("checkoutdrift", func(r *Repo) {
+			r.Local.CheckoutDrift = []string{"example.com/dep@v1.2.0 in /gopath/src/example.com/dep"}
+		}),
+		repo("pseudoversiondrift", func(r *Repo) {
+			r.Local.PseudoVersionDrift = []string{
+				"example.com/dep@v0.0.0-20200101000000-abcdef123456 in /gopath/src/example.com/dep is ahead of it at 0123456789abcdef0123456789abcdef01234567",
+				"example.com/other@v1.2.1-0.20200101000000-abcdef123456 in /gopath/src/example.com/other doesn't have its commit abcdef123456 (fetch it?)",
+			}
+		}),
+		repo("operation", func(r *Repo) {
+			r.Local.Operation = "rebase"
+			r.Local.Status = "UU a.go\n"
+			r.Local.Changes = parseChanges("git", r.Local.Status)
+		}),
+		repo("behind", behind),
+		repo("ahead", func(r *Repo) {
+			r.Local.Revision = "rev2"
+			r.Remote.ContainsLocalRevision = false
+		}),
+		repo("diverged", func(r *Repo) {
+			r.Local.Revision = "rev2"
+			r.Local.ContainsRemoteRevision = false
+			r.Remote.ContainsLocalRevision = false
+		}),
+		repo("inconsistent", func(r *Repo) {
+			r.Local.Revision = "rev2"
+			r.Inconsistency = "local revision rev2 and remote revision rev1 contain each other, yet they differ"
+		}),
+		repo("noremote", func(r *Repo) {
+			r.Local.RemoteURL = ""
+			r.Remote.Revision = ""
+		}),
+		repo("notfound", func(r *Repo) {
+			r.Remote.Revision = ""
+			r.Remote.NotFound = vcsstate.NotFoundError{Err: errors.New("exit status 128: remote: Repository not found.")}
+		}),
+		repo("unreachable", func(r *Repo) {
+			r.Remote.Revision = ""
+			r.Remote.Unreachable = AuthRequired
+			r.addError("RemoteBranchAndRevision", errors.New("exit status 128: fatal: Authentication failed"))
+		}),
+		repo("fork", func(r *Repo) { r.Local.RemoteURL = "git@example.com:user/fork" }),
+		repo("shallow", func(r *Repo) {
+			behind(r)
+			r.Local.Shallow = true
+		}),
+		repo("stale", func(r *Repo) {
+			r.Remote.CommitTime = testNow.Add(-400 * 24 * time.Hour)
+			r.Local.FetchTime = testNow.Add(-100 * 24 * time.Hour)
+		}),
+		repo("stash", func(r *Repo) {
+			r.Local.Stash = "stash@{0}: On feature: Experiment\nstash@{1}: WIP on master: rev1 Commit message\n"
+			r.Local.Stashes = []StashEntry{
+				{Name: "stash@{0}", Message: "Experiment", Branch: "feature", Time: testNow.Add(-2 * time.Hour)},
+				{Name: "stash@{1}", Message: "rev1 Commit message", Branch: "master", Time: testNow.Add(-3 * 365 * 24 * time.Hour)},
+			}
+		}),
+		repo("shelves", func(r *Repo) {
+			r.Local.Stash = "default         (2h ago)    changes to: Commit message\n"
+			r.Local.Stashes = []StashEntry{{Name: "default", Message: "changes to: Commit message", Time: testNow.Add(-2 * time.Hour)}}
+		}),
+		repo("bzrshelves", func(r *Repo) {
+			r.Local.Stash = "  1: Shelf message\n"
+			r.Local.Stashes = []StashEntry{{Name: "1", Message: "Shelf message"}}
+		}),
+		repo("submodules", func(r *Repo) {
+			r.Submodules = []*Repo{
+				repo("submodules/uptodate", func(sub *Repo) {
+					sub.Submodule = &Submodule{Initialized: true, RecordedRevision: "rev1", Revision: "rev1"}
+				}),
+				repo("submodules/moved", func(sub *Repo) {
+					behind(sub)
+					sub.Submodule = &Submodule{Initialized: true, RecordedRevision: "rev1", Revision: "rev0"}
+				}),
+				{Path: "/gopath/src/example.com/submodules/uninitialized", Root: "example.com/submodules/uninitialized", Submodule: &Submodule{RecordedRevision: "rev1"}},
+			}
+		}),
+		repo("worktrees", func(r *Repo) {
+			r.Worktrees = []*Repo{
+				repo("worktrees", func(wt *Repo) {
+					wt.Path = "/worktrees/feature"
+					wt.Worktree = &Worktree{Revision: "rev1"}
+					wt.Local.Branch = "feature"
+				}),
+				repo("worktrees", func(wt *Repo) {
+					behind(wt)
+					wt.Path = "/worktrees/fix"
+					wt.Worktree = &Worktree{Revision: "rev0", Upstream: "origin/fix"}
+					wt.Local.Branch = "fix"
+				}),
+				repo("worktrees", func(wt *Repo) {
+					wt.Path = "/worktrees/removed"
+					wt.Worktree = &Worktree{Missing: true}
+					wt.Local.Branch = ""
+					wt.vcs = nil
+				}),
+			}
+		}),
+		repo("replaces", func(r *Repo) {
+			r.Modules = []*Module{{Path: "example.com/replaces", Dir: r.Path, Main: true}}
+			replace := func(name string, modify func(rep *Repo)) *Repo {
+				return repo(name, func(rep *Repo) {
+					rep.Path = "/gopath/src/example.com/replaces/" + name
+					rep.Replacement = &Replacement{Old: moduleVersion{Path: "example.com/" + name}, Dir: "./" + name, ModulePath: "example.com/" + name}
+					modify(rep)
+				})
+			}
+			r.Replacements = []*Repo{
+				replace("clean", func(*Repo) {}),
+				replace("missing", func(rep *Repo) {
+					rep.Replacement.Missing = true
+					rep.Replacement.ModulePath = ""
+					rep.vcs = nil
+				}),
+				replace("novcs", func(rep *Repo) {
+					rep.Replacement.Old.Version = "v1.0.0"
+					rep.vcs = nil
+				}),
+				replace("dirty", func(rep *Repo) {
+					rep.Local.Status = " M a.go\n"
+					rep.Local.Changes = parseChanges("git", rep.Local.Status)
+				}),
+				replace("behind", behind),
+				replace("renamed", func(rep *Repo) { rep.Replacement.ModulePath = "example.com/fork" }),
+				replace("nogomod", func(rep *Repo) { rep.Replacement.ModulePath = "" }),
+				replace("inrepo", func(rep *Repo) {
+					rep.Replacement.InRepo = true
+					rep.vcs = nil
+				}),
+			}
+		}),
+	}
+}
+
+func TestPresenters(t *testing.T) {
+	defer func(now0 func() time.Time, stale time.Duration, v, showErrors bool) {
+		now, *staleFlag, *vFlag, *showErrorsFlag = now0, stale, v, showErrors
+	}(now, *staleFlag, *vFlag, *showErrorsFlag)
+	now = func() time.Time { return testNow }
+	*staleFlag = 90 * 24 * time.Hour
+
+	tests := []struct {
+		golden    string
+		presenter RepoPresenter
+		verbose   bool // Whether to use -v and -show-errors.
+	}{
+		{"porcelain.golden", WithNestedRepos(PorcelainPresenter), false},
+		{"porcelain-verbose.golden", WithNestedRepos(PorcelainPresenter), true},
+		{"compact.golden", WithNestedRepos(CompactPresenter), false},
+		{"debug.golden", DebugPresenter, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.golden, func(t *testing.T) {
+			*vFlag, *showErrorsFlag = tc.verbose, tc.verbose
+			var got strings.Builder
+			for _, r := range testRepos() {
+				got.WriteString(tc.presenter(r) + "\n")
+			}
+			golden := filepath.Join("testdata", tc.golden)
+			if *updateFlag {
+				if err := os.WriteFile(golden, []byte(got.String()), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != string(want) {
+				t.Errorf("output doesn't match %s (run go test -update to update it):\ngot:\n%s\nwant:\n%s", golden, got.String(), want)
+			}
+		})
+	}
+}
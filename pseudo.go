@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// pseudoVersionRevision returns the abbreviated commit hash of pseudo-version v,
+// like "abcdef123456" of "v0.0.0-20200101000000-abcdef123456", and reports whether
+// v is a pseudo-version.
+func pseudoVersionRevision(v string) (string, bool) {
+	if i := strings.IndexByte(v, '+'); i != -1 {
+		// Build metadata, like "+incompatible".
+		v = v[:i]
+	}
+	i := strings.LastIndexByte(v, '-')
+	if i == -1 {
+		return "", false
+	}
+	rev, rest := v[i+1:], v[:i]
+	if len(rev) != 12 || strings.Trim(rev, "0123456789abcdef") != "" {
+		return "", false
+	}
+	// The commit time precedes the hash, after the base version or its prerelease.
+	j := len(rest) - len("yyyymmddhhmmss")
+	if j < 1 || (rest[j-1] != '-' && rest[j-1] != '.') || strings.Trim(rest[j:], "0123456789") != "" {
+		return "", false
+	}
+	return rev, true
+}
+
+// pseudoVersionDrift returns descriptions of the local git checkouts in GOPATH of requirements
+// of the main module in directory modDir at pseudo-versions, whose checked out revision
+// isn't the one the pseudo-version refers to. Replaced requirements are skipped,
+// since it's the replacement that's built rather than the pseudo-version.
+func pseudoVersionDrift(modDir string) ([]string, error) {
+	gomod, err := readGoMod(filepath.Join(modDir, "go.mod"))
+	if err != nil {
+		return nil, err
+	}
+	var drift []string
+	for _, req := range gomod.Require {
+		rev, ok := pseudoVersionRevision(req.Version)
+		if !ok {
+			continue
+		}
+		if _, replaced := gomod.replacement(req); replaced {
+			continue
+		}
+		dir, ok := localCheckout(modDir, gomod, req)
+		if !ok {
+			continue
+		}
+		if _, vcsType, ok := repoRootDir(dir); !ok || vcsType != "git" {
+			continue
+		}
+		d, err := checkedOutRelativeTo(dir, rev)
+		if err != nil {
+			return drift, fmt.Errorf("%v: %v", req, err)
+		}
+		if d != "" {
+			drift = append(drift, fmt.Sprintf("%v in %s %s", req, dir, d))
+		}
+	}
+	return drift, nil
+}
+
+// checkedOutRelativeTo describes how the revision checked out in git repository dir relates
+// to revision rev, like "is ahead of it at <revision>". It returns empty string if it is rev.
+func checkedOutRelativeTo(dir, rev string) (string, error) {
+	commit, err := gitOutput(dir, "rev-parse", "--verify", "--quiet", rev+"^{commit}")
+	if err != nil {
+		return "doesn't have its commit " + rev + " (fetch it?)", nil
+	}
+	head, err := gitOutput(dir, "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	if head == commit {
+		return "", nil
+	}
+	if ahead, err := gitIsAncestor(dir, commit, head); err != nil {
+		return "", err
+	} else if ahead {
+		return "is ahead of it at " + head, nil
+	}
+	if behind, err := gitIsAncestor(dir, head, commit); err != nil {
+		return "", err
+	} else if behind {
+		return "is behind it at " + head, nil
+	}
+	return "is at unrelated revision " + head, nil
+}
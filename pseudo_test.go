@@ -0,0 +1,79 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPseudoVersionRevision(t *testing.T) {
+	tests := []struct {
+		v    string
+		want string // Empty if v isn't a pseudo-version.
+	}{
+		{"v0.0.0-20200101000000-abcdef123456", "abcdef123456"},
+		{"v1.2.4-0.20200101000000-abcdef123456", "abcdef123456"},
+		{"v1.2.3-pre.0.20200101000000-abcdef123456", "abcdef123456"},
+		{"v2.0.1-0.20200101000000-abcdef123456+incompatible", "abcdef123456"},
+		{"v1.0.0", ""},
+		{"v1.0.0-rc.1", ""},
+		{"v0.0.0-20200101000000-ABCDEF123456", ""},
+		{"v0.0.0-2020010100000-abcdef123456", ""},
+	}
+	for _, tc := range tests {
+		got, ok := pseudoVersionRevision(tc.v)
+		if got != tc.want || ok != (tc.want != "") {
+			t.Errorf("%s: got %q, %v; want %q", tc.v, got, ok, tc.want)
+		}
+	}
+}
+
+func TestPseudoVersionDrift(t *testing.T) {
+	dir := newDepCheckout(t)
+	head := func() string {
+		t.Helper()
+		rev, err := gitOutput(dir, "rev-parse", "HEAD")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rev
+	}
+	first := head()
+	git(t, dir, "commit", "--quiet", "--allow-empty", "--message=Second commit.")
+	second := head()
+
+	mod := t.TempDir()
+	require := func(rev string) {
+		t.Helper()
+		writeFile(t, filepath.Join(mod, "go.mod"), "module example.com/main\n\ngo 1.19\n\nrequire example.com/dep v0.0.0-20200101000000-"+rev[:12]+"\n")
+	}
+	check := func(name string, want []string) {
+		t.Helper()
+		got, err := pseudoVersionDrift(mod)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got drift %q, want %q", name, got, want)
+		}
+	}
+	pinned := "example.com/dep@v0.0.0-20200101000000-" + first[:12] + " in " + dir
+
+	require(second)
+	check("matches", nil)
+	require(first)
+	check("ahead", []string{pinned + " is ahead of it at " + second})
+	git(t, dir, "checkout", "--quiet", "--orphan", "unrelated")
+	git(t, dir, "commit", "--quiet", "--message=Unrelated commit.")
+	unrelated := head()
+	check("unrelated", []string{pinned + " is at unrelated revision " + unrelated})
+	git(t, dir, "checkout", "--quiet", first)
+	require(second)
+	check("behind", []string{"example.com/dep@v0.0.0-20200101000000-" + second[:12] + " in " + dir + " is behind it at " + first})
+	require("0123456789ab")
+	check("unknown commit", []string{"example.com/dep@v0.0.0-20200101000000-0123456789ab in " + dir + " doesn't have its commit 0123456789ab (fetch it?)"})
+
+	// Replaced requirements build the replacement, rather than the pseudo-version.
+	writeFile(t, filepath.Join(mod, "go.mod"), "module example.com/main\n\ngo 1.19\n\nrequire example.com/dep v0.0.0-20200101000000-0123456789ab\n\nreplace example.com/dep => "+dir+"\n")
+	check("replaced", nil)
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shurcooL/vcsstate"
+)
+
+// remoteClient is shared by remote queries over HTTP, so that connections to each host
+// are reused across repositories, rather than each query performing its own handshake.
+var remoteClient = newRemoteClient()
+
+// newRemoteClient returns an HTTP client that keeps enough idle connections per host
+// for all workers to reuse them. Hosts that support HTTP/2 get all queries multiplexed
+// over a single connection.
+//
+// Connections per host are limited to one per worker too, so that a query waits for
+// a connection that's about to become idle rather than dialing an extra one.
+//
+// Like git, it trusts only the certificates in GIT_SSL_CAINFO if that's set.
+// If they can't be loaded, it trusts none, so that git reports the problem.
+func newRemoteClient() *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConnsPerHost = parallelism
+	t.MaxConnsPerHost = parallelism
+	if caInfo := os.Getenv("GIT_SSL_CAINFO"); caInfo != "" {
+		roots := x509.NewCertPool()
+		if pem, err := os.ReadFile(caInfo); err == nil {
+			roots.AppendCertsFromPEM(pem)
+		}
+		t.TLSClientConfig = &tls.Config{RootCAs: roots}
+	}
+	return &http.Client{Transport: t, Timeout: time.Minute}
+}
+
+// errUseGit is returned by in-process remote queries when the remote
+// has to be queried by running git instead, e.g., because it requires authentication.
+var errUseGit = errors.New("remote has to be queried by running git")
+
+// gitHTTPEnv lists environment variables that configure git's HTTP transport
+// in ways that in-process queries don't apply.
+var gitHTTPEnv = []string{
+	"GIT_SSL_NO_VERIFY", "GIT_SSL_CAPATH", "GIT_SSL_CERT", "GIT_SSL_KEY",
+	"GIT_SSL_VERSION", "GIT_SSL_CIPHER_LIST", "GIT_HTTP_PROXY_AUTHMETHOD", "GIT_HTTP_USER_AGENT",
+	"GIT_CURL_VERBOSE", "NETRC",
+}
+
+// gitRemoteHead returns the default branch and revision of the git remote with URL remoteURL
+// of the repository containing dir, using the smart HTTP protocol with client.
+// It returns errUseGit for remotes that aren't plain HTTP or HTTPS URLs,
+// when there's HTTP configuration that only git applies, like http.* config,
+// most GIT_SSL_* environment variables or a .netrc file, and for responses and
+// transport errors that git should handle, like authentication challenges
+// or certificates that git trusts.
+func gitRemoteHead(client *http.Client, dir, remoteURL string) (branch, revision string, err error) {
+	u, err := url.Parse(remoteURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.User != nil {
+		return "", "", errUseGit
+	}
+	for _, env := range gitHTTPEnv {
+		if _, ok := os.LookupEnv(env); ok {
+			return "", "", errUseGit
+		}
+	}
+	if netrcExists() {
+		// Credentials that curl would send.
+		return "", "", errUseGit
+	}
+	g, err := openGitRepo(dir)
+	if err != nil {
+		return "", "", errUseGit
+	}
+	for _, e := range g.config {
+		if e.Section == "http" {
+			// Proxies, certificates, extra headers and the like.
+			return "", "", errUseGit
+		}
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimSuffix(remoteURL, "/")+"/info/refs?service=git-upload-pack", nil)
+	if err != nil {
+		return "", "", errUseGit
+	}
+	// Some hosts only serve the smart protocol to git clients.
+	req.Header.Set("User-Agent", "git/gostatus")
+	resp, err := client.Do(req)
+	if err != nil {
+		// Network and TLS errors are left for git to report, so that they're reported
+		// the same way whether the remote is queried in-process or not.
+		return "", "", errUseGit
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-git-upload-pack-advertisement" {
+		return "", "", errUseGit
+	}
+	branch, revision, err = parseRefAdvertisement(resp.Body)
+	if err != nil {
+		return "", "", errUseGit
+	}
+	return branch, revision, nil
+}
+
+// gitLsRemote returns the default branch and revision of remote origin of the git repository
+// containing dir, by running git ls-remote. Neither git nor SSH prompt for credentials
+// or unknown host keys, so that queries fail rather than wait for input.
+// Errors include git's standard error, so that they can be classified.
+func gitLsRemote(dir string) (branch, revision string, err error) {
+	cmd := exec.Command("git", "ls-remote", "--symref", "origin", "HEAD")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if !userSSHCommand() {
+		cmd.Env = append(cmd.Env, "GIT_SSH_COMMAND="+sshCommand)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		err = fmt.Errorf("git ls-remote: %v: %s", err, strings.TrimSpace(stderr.String()))
+		if gitRemoteNotFound(stderr.String()) {
+			return "", "", vcsstate.NotFoundError{Err: err}
+		}
+		return "", "", err
+	}
+	// Output is "ref: refs/heads/<branch>\tHEAD" followed by "<revision>\tHEAD".
+	for _, line := range strings.Split(string(out), "\n") {
+		value, ref, _ := strings.Cut(line, "\t")
+		if ref != "HEAD" {
+			continue
+		}
+		if target := strings.TrimPrefix(value, "ref: refs/heads/"); target != value {
+			branch = target
+		} else {
+			revision = value
+		}
+	}
+	if branch == "" || revision == "" {
+		return "", "", fmt.Errorf("git ls-remote: no HEAD branch in output %q", out)
+	}
+	return branch, revision, nil
+}
+
+// sshCommand is the SSH command git runs for remote queries, unless the user sets one
+// in the environment. It fails rather than prompt for unknown host keys or passwords,
+// since gostatus isn't interactive.
+var sshCommand = "ssh -o StrictHostKeyChecking=yes -o BatchMode=yes"
+
+// userSSHCommand reports whether the user sets the SSH command for git in the environment.
+func userSSHCommand() bool {
+	for _, env := range []string{"GIT_SSH_COMMAND", "GIT_SSH"} {
+		if _, ok := os.LookupEnv(env); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// gitRemoteNotFound reports whether standard error of a failed git remote query
+// means that the remote repository doesn't exist.
+func gitRemoteNotFound(stderr string) bool {
+	stderr = strings.ToLower(stderr)
+	for _, m := range []string{
+		"repository not found",                   // Hosts like GitHub.
+		"does not appear to be a git repository", // Local paths and SSH remotes.
+		"' not found",                            // HTTP 404, like "repository '<url>' not found".
+	} {
+		if strings.Contains(stderr, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// netrcExists reports whether the user has a .netrc file, which git uses for HTTP credentials.
+func netrcExists() bool {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return false
+	}
+	for _, name := range []string{".netrc", "_netrc"} {
+		if _, err := os.Stat(filepath.Join(home, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRefAdvertisement parses a smart HTTP ref advertisement for git-upload-pack,
+// and returns the branch HEAD points to and its revision.
+func parseRefAdvertisement(r io.Reader) (branch, revision string, err error) {
+	br := bufio.NewReader(r)
+	line, err := readPktLine(br)
+	if err != nil {
+		return "", "", err
+	}
+	if string(line) != "# service=git-upload-pack\n" {
+		return "", "", fmt.Errorf("unexpected service line %q", line)
+	}
+	if line, err := readPktLine(br); err != nil || line != nil {
+		return "", "", fmt.Errorf("expected flush packet after service line")
+	}
+	// The first ref line carries capabilities after a NUL byte,
+	// including "symref=HEAD:refs/heads/<branch>".
+	line, err = readPktLine(br)
+	if err != nil {
+		return "", "", err
+	}
+	ref, caps, _ := bytes.Cut(bytes.TrimSuffix(line, []byte("\n")), []byte{0})
+	rev, name, ok := strings.Cut(string(ref), " ")
+	if !ok || name != "HEAD" {
+		// Empty repository, or HEAD that doesn't point to a branch.
+		return "", "", fmt.Errorf("no HEAD in ref advertisement")
+	}
+	for _, c := range strings.Fields(string(caps)) {
+		if strings.HasPrefix(c, "symref=HEAD:refs/heads/") {
+			return strings.TrimPrefix(c, "symref=HEAD:refs/heads/"), rev, nil
+		}
+	}
+	return "", "", fmt.Errorf("no HEAD symref in ref advertisement")
+}
+
+// readPktLine reads a pkt-line, which is a 4 hex digit length including itself,
+// followed by the data. It returns nil for a flush packet.
+func readPktLine(r *bufio.Reader) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	n, err := strconv.ParseUint(string(size[:]), 16, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pkt-line length %q", size)
+	}
+	if n == 0 {
+		return nil, nil
+	} else if n < 4 {
+		return nil, fmt.Errorf("invalid pkt-line length %d", n)
+	}
+	line := make([]byte, n-4)
+	_, err = io.ReadFull(r, line)
+	return line, err
+}
+
+// multiplexSSH makes remote queries reuse SSH connections to each host
+// via OpenSSH connection multiplexing, by adding its options to sshCommand.
+// It returns a function that cleans up the control sockets directory.
+func multiplexSSH() (cleanup func(), err error) {
+	if runtime.GOOS == "windows" {
+		return nil, fmt.Errorf("SSH connection multiplexing is not supported on %v", runtime.GOOS)
+	}
+	if userSSHCommand() {
+		return nil, fmt.Errorf("GIT_SSH_COMMAND or GIT_SSH is already set")
+	}
+	// Control socket paths are limited in length, so use a short base directory.
+	dir, err := os.MkdirTemp("/tmp", "gostatus-ssh")
+	if err != nil {
+		return nil, err
+	}
+	// Masters linger only briefly after the last query, so they don't outlive gostatus for long.
+	sshCommand += " -o ControlMaster=auto -o ControlPath=" + dir + "/%C -o ControlPersist=10s"
+	return func() { os.RemoveAll(dir) }, nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/shurcooL/vcsstate"
+)
+
+// newSmartHTTPServer starts a stand-in for a git smart HTTP host, which advertises
+// a master branch at revision for any repository. It counts accepted connections in conns.
+func newSmartHTTPServer(t testing.TB, revision string, conns *int64) *httptest.Server {
+	pktLine := func(s string) string { return fmt.Sprintf("%04x%s", len(s)+4, s) }
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !strings.HasSuffix(req.URL.Path, "/info/refs") || req.URL.Query().Get("service") != "git-upload-pack" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+		fmt.Fprint(w, pktLine("# service=git-upload-pack\n")+"0000"+
+			pktLine(revision+" HEAD\x00multi_ack symref=HEAD:refs/heads/master agent=git/stand-in\n")+
+			pktLine(revision+" refs/heads/master\n")+"0000")
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(conns, 1)
+		}
+	}
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newHTTPRemoteRepos creates n git repositories whose remotes are repositories on srv,
+// and returns their directories.
+func newHTTPRemoteRepos(t testing.TB, srv *httptest.Server, n int) []string {
+	setGitTestEnv(t)
+	tmp := t.TempDir()
+	var dirs []string
+	for i := 0; i < n; i++ {
+		dir := filepath.Join(tmp, fmt.Sprint("repo", i))
+		git(t, tmp, "init", "--quiet", dir)
+		git(t, dir, "remote", "add", "origin", fmt.Sprintf("%s/user/repo%d.git", srv.URL, i))
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+const testRevision = "0123456789abcdef0123456789abcdef01234567"
+
+func TestGitRemoteHead(t *testing.T) {
+	var conns int64
+	srv := newSmartHTTPServer(t, testRevision, &conns)
+	dirs := newHTTPRemoteRepos(t, srv, 4*parallelism)
+	t.Setenv("HOME", t.TempDir()) // No .netrc.
+	execGit, _ := newGitBackends(t)
+
+	// The in-process query must agree with git.
+	wantBranch, wantRevision, err := execGit.(vcsBackend).VCS.RemoteBranchAndRevision(dirs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wantBranch != "master" || wantRevision != testRevision {
+		t.Fatalf("git got %q, %q; want master, %q", wantBranch, wantRevision, testRevision)
+	}
+
+	// Querying all repositories in parallel, like workspace does, must reuse connections.
+	atomic.StoreInt64(&conns, 0)
+	client := newRemoteClient()
+	var wg sync.WaitGroup
+	dirsCh := make(chan string)
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for dir := range dirsCh {
+				remoteURL, err := execGit.RemoteURL(dir)
+				if err != nil {
+					t.Error(err)
+					continue
+				}
+				branch, revision, err := gitRemoteHead(client, dir, remoteURL)
+				if err != nil {
+					t.Errorf("%s: %v", dir, err)
+				} else if branch != wantBranch || revision != wantRevision {
+					t.Errorf("%s: got %q, %q; want %q, %q", dir, branch, revision, wantBranch, wantRevision)
+				}
+			}
+		}()
+	}
+	for _, dir := range dirs {
+		dirsCh <- dir
+	}
+	close(dirsCh)
+	wg.Wait()
+	if got := atomic.LoadInt64(&conns); got > parallelism {
+		t.Errorf("%d repositories were queried over %d connections, want at most %d", len(dirs), got, parallelism)
+	}
+}
+
+func TestGitRemoteHeadUseGit(t *testing.T) {
+	var conns int64
+	srv := newSmartHTTPServer(t, testRevision, &conns)
+	dirs := newHTTPRemoteRepos(t, srv, 1)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	client := newRemoteClient()
+
+	t.Run("GIT_SSL_NO_VERIFY", func(t *testing.T) {
+		t.Setenv("GIT_SSL_NO_VERIFY", "1")
+		if _, _, err := gitRemoteHead(client, dirs[0], srv.URL+"/user/repo0.git"); err != errUseGit {
+			t.Errorf("got error %v, want errUseGit", err)
+		}
+	})
+	t.Run(".netrc", func(t *testing.T) {
+		netrc := filepath.Join(home, ".netrc")
+		writeFile(t, netrc, "machine example.com login gostatus password secret\n")
+		defer os.Remove(netrc)
+		if _, _, err := gitRemoteHead(client, dirs[0], srv.URL+"/user/repo0.git"); err != errUseGit {
+			t.Errorf("got error %v, want errUseGit", err)
+		}
+	})
+
+	tlsSrv := httptest.NewUnstartedServer(srv.Config.Handler)
+	tlsSrv.Config.ErrorLog = log.New(io.Discard, "", 0) // Handshakes with untrusted certificates fail.
+	tlsSrv.StartTLS()
+	defer tlsSrv.Close()
+	t.Run("untrusted certificate", func(t *testing.T) {
+		if _, _, err := gitRemoteHead(client, dirs[0], tlsSrv.URL+"/user/repo0.git"); err != errUseGit {
+			t.Errorf("got error %v, want errUseGit", err)
+		}
+	})
+	t.Run("GIT_SSL_CAINFO", func(t *testing.T) {
+		caInfo := filepath.Join(t.TempDir(), "ca.pem")
+		writeFile(t, caInfo, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsSrv.Certificate().Raw})))
+		t.Setenv("GIT_SSL_CAINFO", caInfo)
+		branch, revision, err := gitRemoteHead(newRemoteClient(), dirs[0], tlsSrv.URL+"/user/repo0.git")
+		if err != nil || branch != "master" || revision != testRevision {
+			t.Errorf("got %q, %q, %v; want master, %q, nil", branch, revision, err, testRevision)
+		}
+	})
+}
+
+func TestMultiplexSSH(t *testing.T) {
+	t.Setenv("GIT_SSH_COMMAND", "")
+	os.Unsetenv("GIT_SSH_COMMAND")
+	t.Setenv("GIT_SSH", "")
+	os.Unsetenv("GIT_SSH")
+	defer func(cmd string) { sshCommand = cmd }(sshCommand)
+
+	cleanup, err := multiplexSSH()
+	if err != nil {
+		t.Skip("SSH connection multiplexing not supported:", err)
+	}
+	defer cleanup()
+	for _, opt := range []string{"StrictHostKeyChecking=yes", "BatchMode=yes", "ControlMaster=auto", "ControlPersist="} {
+		if !strings.Contains(sshCommand, opt) {
+			t.Errorf("sshCommand %q doesn't include %s", sshCommand, opt)
+		}
+	}
+	if _, ok := os.LookupEnv("GIT_SSH_COMMAND"); ok {
+		t.Error("GIT_SSH_COMMAND is set, want the multiplexing options only in remote queries")
+	}
+}
+
+func BenchmarkRemoteQueries(b *testing.B) {
+	var conns int64
+	srv := newSmartHTTPServer(b, testRevision, &conns)
+	dirs := newHTTPRemoteRepos(b, srv, 10)
+	execGit, _ := newGitBackends(b)
+	for _, bb := range []struct {
+		name  string
+		query func(dir string) error
+	}{
+		{"git", func(dir string) error {
+			_, _, err := execGit.(vcsBackend).VCS.RemoteBranchAndRevision(dir)
+			return err
+		}},
+		{"shared", func(dir string) error {
+			_, _, err := execGit.RemoteBranchAndRevision(dir)
+			return err
+		}},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			atomic.StoreInt64(&conns, 0)
+			for i := 0; i < b.N; i++ {
+				for _, dir := range dirs {
+					if err := bb.query(dir); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+			b.ReportMetric(float64(atomic.LoadInt64(&conns))/float64(b.N), "conns/op")
+		})
+	}
+}
+
+func TestGitLsRemote(t *testing.T) {
+	setGitTestEnv(t)
+	tmp := t.TempDir()
+	remote := filepath.Join(tmp, "remote.git")
+	dir := filepath.Join(tmp, "repo")
+	git(t, tmp, "init", "--quiet", "--bare", "--initial-branch=main", remote)
+	git(t, tmp, "clone", "--quiet", remote, dir)
+	git(t, dir, "commit", "--quiet", "--allow-empty", "--message=Initial commit.")
+	git(t, dir, "push", "--quiet", "origin", "HEAD:main")
+	want, err := gitOutput(dir, "rev-parse", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if branch, revision, err := gitLsRemote(dir); err != nil || branch != "main" || revision != want {
+		t.Errorf("got %q, %q, %v; want main, %q, nil", branch, revision, err, want)
+	}
+
+	if err := os.RemoveAll(remote); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = gitLsRemote(dir)
+	if _, ok := err.(vcsstate.NotFoundError); !ok {
+		t.Errorf("deleted remote: got error %v, want vcsstate.NotFoundError", err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "does not appear to be a git repository") {
+		t.Errorf("deleted remote: got error %v, want it to include git's standard error", err)
+	}
+}
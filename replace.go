@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// Replacement describes a replace directive of a main module's go.mod file
+// that replaces a module with a local directory.
+type Replacement struct {
+	Old        moduleVersion // Replaced module. Its version is empty if all versions are replaced.
+	Dir        string        // Replacement directory, as written in go.mod.
+	Missing    bool          // Whether the replacement directory is missing.
+	InRepo     bool          // Whether it's in the main module's repository, or in one added on its own, so its state is that repository's.
+	ModulePath string        // Module path declared by the go.mod file in the replacement directory, if any.
+}
+
+// modulePathMismatch reports whether the replacement directory doesn't declare
+// the replaced module path, which the go command rejects.
+func (r *Replacement) modulePathMismatch() bool {
+	return !r.Missing && r.ModulePath != r.Old.Path
+}
+
+// replacements returns the local directory replacements of main module mod.
+// Replacements in checkouts other than the main module's get a backend of their own,
+// and their state is yet to be computed.
+func replacements(mod *Module) ([]*Repo, error) {
+	gomod, err := readGoMod(filepath.Join(mod.Dir, "go.mod"))
+	if err != nil {
+		return nil, err
+	}
+	modRoot, _, modInRepo := repoRootDir(mod.Dir)
+	var reps []*Repo
+	for _, rep := range gomod.Replace {
+		if !rep.New.localDir() {
+			continue
+		}
+		dir := filepath.FromSlash(rep.New.Path)
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(mod.Dir, dir)
+		}
+		replacement := &Replacement{Old: rep.Old, Dir: rep.New.Path}
+		rr := &Repo{
+			Path:        dir,
+			Root:        rep.Old.Path,
+			Replacement: replacement,
+		}
+		reps = append(reps, rr)
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			replacement.Missing = true
+			continue
+		}
+		if f, err := readGoMod(filepath.Join(dir, "go.mod")); err == nil {
+			replacement.ModulePath = f.Module
+		} else if !os.IsNotExist(err) {
+			rr.addError("ReadGoMod", err)
+		}
+		root, vcsType, ok := repoRootDir(dir)
+		if !ok {
+			// Replacement directory not under VCS.
+			continue
+		}
+		if modInRepo && root == modRoot {
+			// The main module's repository covers the replacement's state.
+			replacement.InRepo = true
+			continue
+		}
+		vcsCmd := vcs.ByCmd(vcsType)
+		if vcs, err := newBackend(vcsCmd); err == nil {
+			rr.vcs = vcs
+		} else {
+			rr.vcsError = fmt.Errorf("%v not supported by vcsstate: %v", vcsCmd.Name, err)
+		}
+	}
+	return reps, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplacements(t *testing.T) {
+	setGitTestEnv(t)
+	mod := t.TempDir()
+	writeFile(t, filepath.Join(mod, "go.mod"), `module example.com/main
+
+go 1.19
+
+replace (
+	example.com/clean => ./clean
+	example.com/missing => ./missing
+	example.com/novcs v1.0.0 => ./novcs
+	example.com/renamed => ./renamed
+	example.com/fork => example.com/upstream v1.0.0
+)
+`)
+	for _, name := range []string{"clean", "renamed"} {
+		git(t, mod, "init", "--quiet", name)
+	}
+	if err := os.Mkdir(filepath.Join(mod, "novcs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(mod, "clean", "go.mod"), "module example.com/clean\n")
+	writeFile(t, filepath.Join(mod, "novcs", "go.mod"), "module example.com/novcs\n")
+	writeFile(t, filepath.Join(mod, "renamed", "go.mod"), "module example.com/other\n")
+
+	reps, err := replacements(&Module{Path: "example.com/main", Dir: mod, Main: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		dir      string
+		missing  bool
+		vcs      bool
+		mismatch bool
+	}{
+		{dir: "./clean", vcs: true},
+		{dir: "./missing", missing: true},
+		{dir: "./novcs"},
+		{dir: "./renamed", vcs: true, mismatch: true},
+	}
+	if len(reps) != len(tests) {
+		t.Fatalf("got %d replacements, want %d", len(reps), len(tests))
+	}
+	for i, tc := range tests {
+		r := reps[i]
+		if r.Replacement.Dir != tc.dir {
+			t.Errorf("replacement %d: got dir %q, want %q", i, r.Replacement.Dir, tc.dir)
+			continue
+		}
+		if got := r.Replacement.Missing; got != tc.missing {
+			t.Errorf("%s: got missing %v, want %v", tc.dir, got, tc.missing)
+		}
+		if got := r.vcs != nil; got != tc.vcs {
+			t.Errorf("%s: got under VCS %v, want %v", tc.dir, got, tc.vcs)
+		}
+		if got := r.Replacement.modulePathMismatch(); got != tc.mismatch {
+			t.Errorf("%s: got module path mismatch %v, want %v", tc.dir, got, tc.mismatch)
+		}
+	}
+}
+
+func TestReplacementsInRepo(t *testing.T) {
+	setGitTestEnv(t)
+	mod := filepath.Join(t.TempDir(), "main")
+	git(t, filepath.Dir(mod), "init", "--quiet", mod)
+	writeFile(t, filepath.Join(mod, "go.mod"), "module example.com/main\n\ngo 1.19\n\nreplace (\n\texample.com/inrepo => ./inrepo\n\texample.com/own => ./own\n)\n")
+	if err := os.Mkdir(filepath.Join(mod, "inrepo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(mod, "inrepo", "go.mod"), "module example.com/inrepo\n")
+	git(t, mod, "init", "--quiet", "own")
+	writeFile(t, filepath.Join(mod, "own", "go.mod"), "module example.com/own\n")
+
+	reps, err := replacements(&Module{Path: "example.com/main", Dir: mod, Main: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reps) != 2 {
+		t.Fatalf("got %d replacements, want 2", len(reps))
+	}
+	if r := reps[0]; !r.Replacement.InRepo || r.vcs != nil || r.Replacement.modulePathMismatch() {
+		t.Errorf("./inrepo: got in repo %v, under VCS %v, module path %q; want in repo without a backend of its own", r.Replacement.InRepo, r.vcs != nil, r.Replacement.ModulePath)
+	}
+	if r := reps[1]; r.Replacement.InRepo || r.vcs == nil {
+		t.Errorf("./own: got in repo %v, under VCS %v; want a repository of its own", r.Replacement.InRepo, r.vcs != nil)
+	}
+}
+
+// TestNonVCSModuleReplacements checks that replacements of a main module
+// that isn't under VCS are reported too.
+func TestNonVCSModuleReplacements(t *testing.T) {
+	mod := t.TempDir()
+	writeFile(t, filepath.Join(mod, "go.mod"), "module example.com/main\n\ngo 1.19\n\nreplace example.com/dep => ./dep\n")
+	r := &Repo{Path: mod, Root: "example.com/main", Modules: []*Module{{Path: "example.com/main", Dir: mod, Main: true}}}
+	newTestWorkspace().computeVCSState(r)
+	if len(r.Replacements) != 1 || !r.Replacements[0].Replacement.Missing {
+		t.Fatalf("got replacements %+v, want ./dep missing", r.Replacements)
+	}
+}
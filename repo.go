@@ -1,18 +1,42 @@
 package main
 
-import "github.com/shurcooL/vcsstate"
+import (
+	"encoding/json"
+	"time"
+)
 
 // Repo represents a repository that contains Go packages and its state when VCS is non-nil.
-// It represents a Go package that is not under a VCS when VCS is nil.
+// It represents a Go package or an orphaned directory that is not under a VCS when VCS is nil.
 type Repo struct {
 	// Path is the local filesystem path to the repository or Go package.
 	Path string
 
 	// Root is the import path corresponding to the root of the repository or Go package.
+	// For repositories found outside GOPATH by scanning a directory, it's inferred
+	// from the remote URL, or is the directory if there's no remote.
 	Root string
 
+	// rootInferred is set when Root wasn't derived from the repository's location in GOPATH,
+	// so it can't be used to verify the remote URL.
+	rootInferred bool
+
+	// Modules are the Go modules that the packages in the repository belong to, sorted by directory,
+	// when they were loaded in module mode.
+	Modules []*Module `json:",omitempty"`
+
+	// Submodule is set when the repository is a git submodule of another repository.
+	Submodule *Submodule `json:",omitempty"`
+
+	// Worktree is set when the repository is a linked git worktree of another repository.
+	// Its Root is that of the repository it belongs to.
+	Worktree *Worktree `json:",omitempty"`
+
+	// Replacement is set when the repository is the local directory that a module is replaced with
+	// by a replace directive of a main module. Its Root is the replaced module path.
+	Replacement *Replacement `json:",omitempty"`
+
 	// vcs allows getting the state of the VCS. It's nil if there's no VCS.
-	vcs      vcsstate.VCS
+	vcs      backend
 	vcsError error
 
 	Local struct {
@@ -20,20 +44,102 @@ type Repo struct {
 		RemoteURL string
 
 		Status   string
-		Branch   string // Checked out branch.
+		Changes  Changes // Breakdown of uncommitted changes in Status.
+		Branch   string  // Checked out branch.
 		Revision string
 		Stash    string
+		Stashes  []StashEntry // Stash entries, newest first.
+
+		// NestedIn is the root directory of the repository whose working tree the repository
+		// is nested in, if that repository neither ignores nor tracks it.
+		NestedIn string
+
+		// VendorDrift describes how the vendor directory of the repository's main module
+		// differs from its go.mod file and from the vendored module versions, if it does.
+		VendorDrift []string
+
+		// CheckoutDrift lists the local checkouts of requirements of the repository's main module,
+		// in GOPATH or replacement directories, that have the required version checked out,
+		// but whose contents differ from what go.sum pins.
+		CheckoutDrift []string
 
-		ContainsRemoteRevision bool // Computed if Remote.Revision != "".
+		// PseudoVersionDrift lists the local git checkouts in GOPATH of requirements of the repository's
+		// main module at pseudo-versions, whose checked out revision isn't the pseudo-version's commit.
+		PseudoVersionDrift []string
+
+		// Operation is the operation in progress, like "merge", "rebase" or "bisect".
+		// It's empty if there's no operation in progress.
+		Operation string
+
+		Shallow            bool   // Whether it's a shallow clone, with truncated history.
+		PartialCloneFilter string // Object filter, like "blob:none", if it's a partial clone.
+
+		CommitTime time.Time // Commit time of the checked out revision.
+		FetchTime  time.Time // Time of the last fetch from remote.
+
+		ContainsRemoteRevision bool // Computed if Remote.Revision != "" and history is complete.
 	}
 	Remote struct {
 		// RepoURL is the repository URL, including scheme, as determined dynamically from the import path.
 		RepoURL string
 
-		NotFound error  // Whether remote repository was not found.
-		Branch   string // Default branch, as determined from remote.
-		Revision string
+		NotFound    error             // Whether remote repository was not found.
+		Unreachable UnreachableReason // Why the remote is unreachable, if it is.
+		Branch      string            // Default branch, as determined from remote.
+		Revision    string
+
+		CommitTime time.Time // Commit time of Revision, or of the last fetched remote revision if Revision is unknown locally.
 
-		ContainsLocalRevision bool // Computed if Local.Revision != "".
+		ContainsLocalRevision bool // Computed if Local.Revision != "" and history is complete.
 	}
+
+	// Inconsistency describes revision state that shouldn't be possible, like both local
+	// and remote revisions containing each other, yet being different. It's empty if there's none.
+	Inconsistency string `json:",omitempty"`
+
+	// Errors are the errors encountered while computing the state of the repository.
+	Errors []error `json:",omitempty"`
+
+	// Submodules are the git submodules of the repository.
+	Submodules []*Repo `json:",omitempty"`
+
+	// Worktrees are the other git worktrees of the repository.
+	Worktrees []*Repo `json:",omitempty"`
+
+	// Replacements are the local directories that modules are replaced with by the repository's main modules.
+	Replacements []*Repo `json:",omitempty"`
+}
+
+// checkedOutRevision returns the checked out revision of a submodule or linked worktree.
+// Their local state is relative to it, rather than to the local default branch.
+func (r *Repo) checkedOutRevision() (rev string, ok bool) {
+	switch {
+	case r.Submodule != nil:
+		return r.Submodule.Revision, true
+	case r.Worktree != nil:
+		return r.Worktree.Revision, true
+	default:
+		return "", false
+	}
+}
+
+// addError records err encountered during operation op.
+func (r *Repo) addError(op string, err error) {
+	r.Errors = append(r.Errors, &OpError{Op: op, Err: err})
+}
+
+// OpError records an error and the operation that caused it.
+type OpError struct {
+	Op  string // Operation that failed, e.g., "RemoteBranchAndRevision".
+	Err error
+}
+
+func (e *OpError) Error() string { return e.Op + ": " + e.Err.Error() }
+
+// MarshalJSON implements json.Marshaler, since most errors don't have exported fields.
+func (e *OpError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Op  string
+		Err string
+	}{e.Op, e.Err.Error()})
 }
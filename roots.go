@@ -0,0 +1,87 @@
+package main
+
+import (
+	"go/build"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// vcsMetadataDirs are the directories whose presence vcs.FromDir uses to detect a repository root.
+var vcsMetadataDirs = []string{".hg", ".git", ".svn", ".bzr"}
+
+// repoRoots is an index of discovered repository roots by import path. It lets packages
+// of already discovered repositories be deduplicated without locating them on disk.
+type repoRoots struct {
+	mu    sync.Mutex
+	dirs  map[string]string // Root import path -> directory.
+	plain map[string]bool   // Directories known not to be repository roots.
+}
+
+func newRepoRoots() *repoRoots {
+	return &repoRoots{dirs: make(map[string]string), plain: make(map[string]bool)}
+}
+
+// add adds the repository root with import path root at directory dir.
+func (rr *repoRoots) add(root, dir string) {
+	rr.mu.Lock()
+	rr.dirs[root] = dir
+	rr.mu.Unlock()
+}
+
+// lookup returns the root of the known repository that package importPath is in.
+// It reports false if there's no such repository, if the package may be
+// in a repository nested in the known one, or if the package's directory
+// doesn't exist, so it has to be located on disk (and reported if it's not found).
+func (rr *repoRoots) lookup(importPath string) (root string, ok bool) {
+	if build.IsLocalImport(importPath) || filepath.IsAbs(importPath) {
+		return "", false
+	}
+	var dir string
+	rr.mu.Lock()
+	for root = importPath; root != "."; root = path.Dir(root) {
+		if dir, ok = rr.dirs[root]; ok {
+			break
+		}
+	}
+	rr.mu.Unlock()
+	if !ok {
+		return "", false
+	}
+	// Only directories between the root and the package can be roots of nested repositories,
+	// so checking them is much cheaper than locating the package.
+	for _, elem := range strings.Split(strings.TrimPrefix(importPath, root), "/") {
+		if elem == "" {
+			continue
+		}
+		dir = filepath.Join(dir, elem)
+		if !rr.isPlain(dir) {
+			return "", false
+		}
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return "", false
+	}
+	return root, true
+}
+
+// isPlain reports whether directory dir isn't a repository root.
+func (rr *repoRoots) isPlain(dir string) bool {
+	rr.mu.Lock()
+	plain := rr.plain[dir]
+	rr.mu.Unlock()
+	if plain {
+		return true
+	}
+	for _, name := range vcsMetadataDirs {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return false
+		}
+	}
+	rr.mu.Lock()
+	rr.plain[dir] = true
+	rr.mu.Unlock()
+	return true
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestRepoRoots(t *testing.T) {
+	src := t.TempDir()
+	for _, dir := range []string{"example.com/repo/.git", "example.com/repo/nested/.hg", "example.com/repo/pkg/sub"} {
+		if err := os.MkdirAll(filepath.Join(src, filepath.FromSlash(dir)), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rr := newRepoRoots()
+	rr.add("example.com/repo", filepath.Join(src, "example.com", "repo"))
+
+	tests := []struct {
+		importPath string
+		wantRoot   string
+		wantOK     bool
+	}{
+		{"example.com/repo", "example.com/repo", true},
+		{"example.com/repo/pkg", "example.com/repo", true},
+		{"example.com/repo/pkg/sub", "example.com/repo", true},
+		{"example.com/repo/nonexistent", "", false}, // Has to be reported as not found.
+		{"example.com/repository", "", false},
+		{"example.com/other", "", false},
+		{"example.com/repo/nested", "", false}, // Root of a nested repository.
+		{"example.com/repo/nested/pkg", "", false},
+		{"./repo", "", false},
+	}
+	for _, tc := range tests {
+		root, ok := rr.lookup(tc.importPath)
+		if root != tc.wantRoot || ok != tc.wantOK {
+			t.Errorf("lookup(%q): got %q, %v; want %q, %v", tc.importPath, root, ok, tc.wantRoot, tc.wantOK)
+		}
+	}
+}
+
+// BenchmarkUniqueWorker measures finding unique repos in a synthetic GOPATH
+// with many packages per repository, with and without the repo root index.
+func BenchmarkUniqueWorker(b *testing.B) {
+	const repos, pkgs = 50, 40
+	gopath := b.TempDir()
+	var importPaths []string
+	for i := 0; i < repos; i++ {
+		root := fmt.Sprintf("example.com/user/repo%d", i)
+		if err := os.MkdirAll(filepath.Join(gopath, "src", root, ".git"), 0755); err != nil {
+			b.Fatal(err)
+		}
+		importPaths = append(importPaths, root)
+		for j := 0; j < pkgs; j++ {
+			importPath := fmt.Sprintf("%s/pkg%d/sub%d", root, j/4, j%4)
+			if err := os.MkdirAll(filepath.Join(gopath, "src", importPath), 0755); err != nil {
+				b.Fatal(err)
+			}
+			importPaths = append(importPaths, importPath)
+		}
+	}
+	b.Setenv("GO111MODULE", "off")
+	defer func(gopath string) { build.Default.GOPATH = gopath }(build.Default.GOPATH)
+	build.Default.GOPATH = gopath
+
+	for _, bb := range []struct {
+		name  string
+		index bool
+	}{
+		{"build.Import", false},
+		{"index", true},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				w := &workspace{
+					ImportPaths: make(chan string, len(importPaths)),
+					unique:      make(chan *Repo, len(importPaths)),
+					Errors:      make(chan error, len(importPaths)),
+					repos:       make(map[string]*Repo),
+				}
+				if bb.index {
+					w.roots = newRepoRoots()
+				}
+				for _, importPath := range importPaths {
+					w.ImportPaths <- importPath
+				}
+				close(w.ImportPaths)
+				var wg sync.WaitGroup
+				for range [parallelism]struct{}{} {
+					wg.Add(1)
+					go w.uniqueWorker(&wg)
+				}
+				wg.Wait()
+				if len(w.Errors) > 0 {
+					b.Fatal(<-w.Errors)
+				}
+				if len(w.unique) != repos {
+					b.Fatalf("got %d unique repos, want %d", len(w.unique), repos)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// daysFlag is a time.Duration flag value that additionally accepts
+// a number of days, like "90d".
+type daysFlag time.Duration
+
+func newDaysFlag(name string, usage string) *time.Duration {
+	var d time.Duration
+	flag.Var((*daysFlag)(&d), name, usage)
+	return &d
+}
+
+func (d *daysFlag) String() string {
+	if d == nil || *d == 0 {
+		return ""
+	}
+	return time.Duration(*d).String()
+}
+
+func (d *daysFlag) Set(s string) error {
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return fmt.Errorf("invalid number of days %q", s)
+		}
+		*d = daysFlag(time.Duration(n) * 24 * time.Hour)
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = daysFlag(v)
+	return nil
+}
+
+// staleRemote reports whether r's remote hasn't had a commit within the -stale duration.
+func staleRemote(r *Repo) bool {
+	return *staleFlag > 0 && !r.Remote.CommitTime.IsZero() && now().Sub(r.Remote.CommitTime) > *staleFlag
+}
+
+// staleFetch reports whether r's local clone hasn't been fetched within the -stale duration.
+func staleFetch(r *Repo) bool {
+	return *staleFlag > 0 && !r.Local.FetchTime.IsZero() && now().Sub(r.Local.FetchTime) > *staleFlag
+}
+
+// formatDays formats d as a whole number of days, like "90 days".
+func formatDays(d time.Duration) string {
+	n := int(d / (24 * time.Hour))
+	return fmt.Sprintf("%d %s", n, plural(n, "day", "days"))
+}
+
+// commitTime returns the commit time of revision rev in the repository containing dir.
+// If rev is empty, the commit time of the checked out revision is returned.
+func commitTime(vcsType, dir, rev string) (time.Time, error) {
+	switch vcsType {
+	case "git":
+		if rev == "" {
+			rev = "HEAD"
+		}
+		cmd := exec.Command("git", "log", "-1", "--format=%ct", rev, "--")
+		cmd.Dir = dir
+		out, err := cmd.Output()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("git log: %v", err)
+		}
+		unix, err := strconv.ParseInt(strings.TrimSuffix(string(out), "\n"), 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("git log: %v", err)
+		}
+		return time.Unix(unix, 0), nil
+	case "hg":
+		if rev == "" {
+			rev = "."
+		}
+		// The hgdate filter formats the date as "<unix time> <timezone offset>".
+		out, err := vcsOutput(dir, "hg", "log", "--rev", rev, "--template", "{date|hgdate}")
+		if err != nil {
+			return time.Time{}, err
+		}
+		unixTime, _, _ := strings.Cut(out, " ")
+		unix, err := strconv.ParseInt(unixTime, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("hg log: %v", err)
+		}
+		return time.Unix(unix, 0), nil
+	case "svn":
+		return svnCommitTime(dir, rev)
+	case "bzr":
+		return bzrCommitTime(dir, ".", rev)
+	default:
+		return time.Time{}, fmt.Errorf("commit time not implemented for %v", vcsType)
+	}
+}
+
+// remoteCommitTime returns the commit time of remote revision in the repository containing dir.
+// If revision is empty, the commit time of the last fetched revision of the remote branch
+// is returned instead. If revision hasn't been fetched, its commit time is unknown,
+// and the zero time is returned, since the last fetched one would be older than it.
+func remoteCommitTime(vcsType, dir, revision, branch string) (time.Time, error) {
+	if revision != "" {
+		if t, err := commitTime(vcsType, dir, revision); err == nil {
+			return t, nil
+		}
+		return time.Time{}, nil
+	}
+	switch vcsType {
+	case "git":
+		ref := "refs/remotes/origin/" + branch
+		if _, err := gitOutput(dir, "rev-parse", "--verify", "--quiet", ref); err != nil {
+			// The remote branch was never fetched.
+			return time.Time{}, nil
+		}
+		return commitTime(vcsType, dir, ref)
+	case "bzr":
+		parent, err := (bzr{}).RemoteURL(dir)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return bzrCommitTime(dir, parent, "")
+	case "hg", "svn":
+		// There are no remote-tracking branches to fall back to.
+		return time.Time{}, nil
+	default:
+		return time.Time{}, fmt.Errorf("remote commit time not implemented for %v", vcsType)
+	}
+}
+
+// fetchTime returns the time the repository containing dir was last fetched from its remote.
+// If it was never fetched since it was cloned, the time of the clone is used.
+//
+// Only git records fetches. Mercurial and Bazaar don't record pulls apart from other changes,
+// and Subversion working copies have nothing to fetch, so the zero time is returned for them.
+func fetchTime(vcsType, dir string) (time.Time, error) {
+	switch vcsType {
+	case "git":
+		metaDir, err := metadataDir(vcsType, dir)
+		if err != nil {
+			return time.Time{}, err
+		}
+		// Linked worktrees keep FETCH_HEAD in the common directory.
+		if commonDir, err := os.ReadFile(filepath.Join(metaDir, "commondir")); err == nil {
+			metaDir = filepath.Join(metaDir, strings.TrimSpace(string(commonDir)))
+		}
+		return gitFetchTime(metaDir)
+	case "hg", "bzr", "svn":
+		return time.Time{}, nil
+	default:
+		return time.Time{}, fmt.Errorf("fetch time not implemented for %v", vcsType)
+	}
+}
+
+// gitFetchTime returns the time a git repository with common directory commonDir
+// was last fetched from its remote, or cloned if it was never fetched.
+// It returns the zero time if there's no record of either.
+func gitFetchTime(commonDir string) (time.Time, error) {
+	for _, name := range []string{"FETCH_HEAD", filepath.Join("refs", "remotes", "origin", "HEAD")} {
+		if fi, err := os.Stat(filepath.Join(commonDir, name)); err == nil {
+			return fi.ModTime(), nil
+		}
+	}
+	return time.Time{}, nil
+}
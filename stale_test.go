@@ -0,0 +1,46 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRemoteCommitTime(t *testing.T) {
+	setGitTestEnv(t)
+	tmp := t.TempDir()
+	remote := filepath.Join(tmp, "remote.git")
+	dir := filepath.Join(tmp, "repo")
+	other := filepath.Join(tmp, "other")
+	old := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	t.Setenv("GIT_COMMITTER_DATE", old.Format(time.RFC3339))
+	git(t, tmp, "init", "--quiet", "--bare", "--initial-branch=master", remote)
+	git(t, tmp, "clone", "--quiet", remote, dir)
+	git(t, dir, "commit", "--quiet", "--allow-empty", "--message=Old commit.")
+	git(t, dir, "push", "--quiet", "origin", "master")
+
+	// A newer commit is pushed from another clone, and isn't fetched yet.
+	recent := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	t.Setenv("GIT_COMMITTER_DATE", recent.Format(time.RFC3339))
+	git(t, tmp, "clone", "--quiet", remote, other)
+	git(t, other, "commit", "--quiet", "--allow-empty", "--message=Recent commit.")
+	git(t, other, "push", "--quiet", "origin", "master")
+	revision, err := gitOutput(other, "rev-parse", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(name, revision string, want time.Time) {
+		t.Helper()
+		got, err := remoteCommitTime("git", dir, revision, "master")
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if !got.Equal(want) {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+	check("unknown remote revision", "", old)
+	check("remote revision not fetched", revision, time.Time{})
+	git(t, dir, "fetch", "--quiet", "origin")
+	check("remote revision fetched", revision, recent)
+}
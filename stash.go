@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StashEntry is a single stash entry.
+type StashEntry struct {
+	Name    string    // Name of the stash entry, e.g., "stash@{0}", or of the Mercurial or Bazaar shelf.
+	Message string    // Message of the stash entry.
+	Branch  string    // Branch the stash entry was made on, if known.
+	Time    time.Time // Time the stash entry was made, if known.
+}
+
+// stashList returns the stash entries of the repository containing dir, newest first.
+func stashList(vcsType, dir string) ([]StashEntry, error) {
+	switch vcsType {
+	case "git":
+		cmd := exec.Command("git", "stash", "list", "--format=%gd%x00%ct%x00%gs")
+		cmd.Dir = dir
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("git stash list: %v", err)
+		}
+		var stashes []StashEntry
+		for _, line := range strings.Split(strings.TrimSuffix(string(out), "\n"), "\n") {
+			if line == "" {
+				continue
+			}
+			fields := strings.SplitN(line, "\x00", 3)
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("git stash list: unexpected line %q", line)
+			}
+			unix, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("git stash list: %v", err)
+			}
+			branch, message := parseGitStashSubject(fields[2])
+			stashes = append(stashes, StashEntry{
+				Name:    fields[0],
+				Message: message,
+				Branch:  branch,
+				Time:    time.Unix(unix, 0),
+			})
+		}
+		return stashes, nil
+	case "hg":
+		return hgShelves(dir)
+	case "bzr":
+		return bzrShelves(dir)
+	case "svn":
+		// See svn.Stash.
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("stash list not implemented for %v", vcsType)
+	}
+}
+
+// parseGitStashSubject parses the reflog subject of a git stash entry,
+// like "WIP on master: 1234567 Commit message" or "On master: Stash message",
+// into the branch it was made on and its message.
+func parseGitStashSubject(subject string) (branch, message string) {
+	for _, prefix := range []string{"WIP on ", "On "} {
+		if !strings.HasPrefix(subject, prefix) {
+			continue
+		}
+		if i := strings.Index(subject, ": "); i != -1 {
+			return subject[len(prefix):i], subject[i+len(": "):]
+		}
+	}
+	return "", subject
+}
+
+// formatAge formats the age d in a human-friendly way, like "3 days ago".
+func formatAge(d time.Duration) string {
+	ago := func(n int, unit string) string {
+		return fmt.Sprintf("%d %s ago", n, plural(n, unit, unit+"s"))
+	}
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return ago(int(d/time.Minute), "minute")
+	case d < 24*time.Hour:
+		return ago(int(d/time.Hour), "hour")
+	case d < 365*24*time.Hour:
+		return ago(int(d/(24*time.Hour)), "day")
+	default:
+		return ago(int(d/(365*24*time.Hour)), "year")
+	}
+}
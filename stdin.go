@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// stdinFormats are the supported formats of the list of Go packages read from stdin.
+var stdinFormats = []string{"lines", "nul", "golist-json"}
+
+// stdinFormatFlag is a flag value that accepts one of stdinFormats.
+type stdinFormatFlag string
+
+func newStdinFormatFlag(name string, value string, usage string) *string {
+	f := stdinFormatFlag(value)
+	flag.Var(&f, name, usage)
+	return (*string)(&f)
+}
+
+func (f *stdinFormatFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return string(*f)
+}
+
+func (f *stdinFormatFlag) Set(s string) error {
+	for _, format := range stdinFormats {
+		if s == format {
+			*f = stdinFormatFlag(s)
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported format %q, want one of: %s", s, strings.Join(stdinFormats, ", "))
+}
+
+// listedPackage is the part of a Go package printed by go list -json that's needed
+// to locate it, so that it doesn't have to be located again.
+type listedPackage struct {
+	ImportPath string
+	Dir        string        // Directory containing package sources.
+	Root       string        // Go root, Go path dir, or module root dir containing this package.
+	Goroot     bool          // Whether the package is in the Go root.
+	Standard   bool          // Whether the package is part of the standard library.
+	Module     *listedModule // Module containing the package, if in module mode.
+	Error      *struct {
+		Err string
+	}
+}
+
+// srcRoot returns the src directory of the GOPATH workspace containing p,
+// or empty string if p isn't in one, like build.Import does.
+func (p *listedPackage) srcRoot() string {
+	if p.Module != nil || p.Goroot || p.Root == "" {
+		return ""
+	}
+	return filepath.Join(p.Root, "src")
+}
+
+// ReadPackages reads the list of Go packages from r in the given format,
+// and sends them to ImportPaths. Packages listed by go list -json are
+// recorded, so they don't have to be located again.
+// It must be called before ImportPaths is closed.
+func (w *workspace) ReadPackages(r io.Reader, format string) error {
+	switch format {
+	case "lines", "nul":
+		sc := bufio.NewScanner(r)
+		if format == "nul" {
+			sc.Split(scanNUL)
+		}
+		for sc.Scan() {
+			// Lines are split with a trailing carriage return dropped, and the last one need not be terminated.
+			importPath := sc.Text()
+			if importPath == "" {
+				continue
+			}
+			w.ImportPaths <- importPath
+		}
+		return sc.Err()
+	case "golist-json":
+		// go list -json prints a stream of JSON objects, rather than an array.
+		dec := json.NewDecoder(r)
+		for {
+			var p listedPackage
+			err := dec.Decode(&p)
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("decoding go list -json output: %v", err)
+			}
+			if p.ImportPath == "" {
+				continue
+			}
+			if p.Dir == "" && p.Error != nil {
+				// The package couldn't be located, so report why rather than locating it again.
+				w.Errors <- errors.New(p.Error.Err)
+				continue
+			}
+			if p.Dir != "" {
+				w.listedMu.Lock()
+				w.listed[p.ImportPath] = &p
+				w.listedMu.Unlock()
+			}
+			w.ImportPaths <- p.ImportPath
+		}
+	default:
+		return fmt.Errorf("unsupported stdin format %q", format)
+	}
+}
+
+// scanNUL is a bufio.SplitFunc that splits NUL-terminated items. The last item
+// need not be terminated.
+func scanNUL(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadPackages(t *testing.T) {
+	tests := []struct {
+		format string
+		in     string
+		want   []string
+	}{
+		{"lines", "example.com/a\nexample.com/b\n", []string{"example.com/a", "example.com/b"}},
+		{"lines", "example.com/a\nexample.com/b", []string{"example.com/a", "example.com/b"}}, // No trailing newline.
+		{"lines", "example.com/a\r\nexample.com/b\r\n", []string{"example.com/a", "example.com/b"}},
+		{"lines", "example.com/a\n\nexample.com/b\n", []string{"example.com/a", "example.com/b"}},
+		{"lines", "", nil},
+		{"nul", "example.com/a\x00example.com/b\x00", []string{"example.com/a", "example.com/b"}},
+		{"nul", "example.com/a\x00example.com/b", []string{"example.com/a", "example.com/b"}},
+		{"golist-json", `{
+	"Dir": "/gopath/src/example.com/a",
+	"ImportPath": "example.com/a",
+	"Root": "/gopath"
+}
+{
+	"Dir": "/goroot/src/fmt",
+	"ImportPath": "fmt",
+	"Root": "/goroot",
+	"Goroot": true,
+	"Standard": true
+}
+`, []string{"example.com/a", "fmt"}},
+	}
+	for _, tc := range tests {
+		w := &workspace{
+			ImportPaths: make(chan string, 10),
+			listed:      make(map[string]*listedPackage),
+		}
+		if err := w.ReadPackages(strings.NewReader(tc.in), tc.format); err != nil {
+			t.Errorf("%s %q: %v", tc.format, tc.in, err)
+			continue
+		}
+		close(w.ImportPaths)
+		var got []string
+		for importPath := range w.ImportPaths {
+			got = append(got, importPath)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s %q: got %q, want %q", tc.format, tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestImportPackageListed(t *testing.T) {
+	w := &workspace{
+		ImportPaths: make(chan string, 10),
+		listed:      make(map[string]*listedPackage),
+	}
+	const in = `{"Dir": "/gopath/src/example.com/a", "ImportPath": "example.com/a", "Root": "/gopath"}
+{"Dir": "/src/mod/b", "ImportPath": "example.com/mod/b", "Root": "/src/mod", "Module": {"Path": "example.com/mod"}}
+{"Dir": "/goroot/src/fmt", "ImportPath": "fmt", "Root": "/goroot", "Goroot": true, "Standard": true}`
+	if err := w.ReadPackages(strings.NewReader(in), "golist-json"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		importPath  string
+		wantDir     string
+		wantSrcRoot string
+		wantGoroot  bool
+	}{
+		{"example.com/a", "/gopath/src/example.com/a", filepath.Join("/gopath", "src"), false},
+		{"example.com/mod/b", "/src/mod/b", "", false}, // Like build.Import in module mode.
+		{"fmt", "/goroot/src/fmt", "", true},
+	}
+	for _, tc := range tests {
+		bpkg, _, err := w.importPackage(tc.importPath)
+		if err != nil {
+			t.Errorf("%s: %v", tc.importPath, err)
+			continue
+		}
+		if bpkg.Dir != tc.wantDir || bpkg.SrcRoot != tc.wantSrcRoot || bpkg.Goroot != tc.wantGoroot {
+			t.Errorf("%s: got Dir %q, SrcRoot %q, Goroot %v; want %q, %q, %v",
+				tc.importPath, bpkg.Dir, bpkg.SrcRoot, bpkg.Goroot, tc.wantDir, tc.wantSrcRoot, tc.wantGoroot)
+		}
+	}
+}
+
+func TestStdinFormatFlag(t *testing.T) {
+	var f stdinFormatFlag
+	for _, format := range stdinFormats {
+		if err := f.Set(format); err != nil || string(f) != format {
+			t.Errorf("Set(%q): got %q, %v", format, f, err)
+		}
+	}
+	if err := f.Set("csv"); err == nil {
+		t.Error("Set(\"csv\"): got nil error, want non-nil")
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Submodule describes a git submodule in relation to its superproject.
+type Submodule struct {
+	Initialized      bool
+	RecordedRevision string // Revision recorded by the superproject.
+	Revision         string // Checked out revision. It's empty if not initialized.
+}
+
+// submoduleCheckout is a git submodule in the working dir of its superproject.
+type submoduleCheckout struct {
+	Dir  string // Directory of the submodule.
+	Path string // Path relative to the superproject root, slash-separated.
+	Submodule
+}
+
+// submodules returns the git submodules of repository r.
+// Initialized submodules share r's backend, and their state is yet to be computed.
+func submodules(r *Repo) ([]*Repo, error) {
+	checkouts, err := r.vcs.Submodules(r.Path)
+	if err != nil {
+		return nil, err
+	}
+	var subs []*Repo
+	for _, c := range checkouts {
+		submodule := c.Submodule
+		sub := &Repo{
+			Path:      c.Dir,
+			Root:      path.Join(r.Root, c.Path),
+			Submodule: &submodule,
+		}
+		if submodule.Initialized {
+			sub.vcs = r.vcs
+		}
+		subs = append(subs, sub)
+	}
+	return subs, nil
+}
+
+// gitSubmodulePaths returns the paths of submodules configured in the .gitmodules file
+// of the git repository with working dir top, or none if there's no such file.
+func gitSubmodulePaths(top string) ([]string, error) {
+	b, err := os.ReadFile(filepath.Join(top, ".gitmodules"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	c, err := parseGitConfig(string(b))
+	if err != nil {
+		return nil, fmt.Errorf(".gitmodules: %v", err)
+	}
+	var paths []string
+	for _, e := range c {
+		if e.Section == "submodule" && e.Key == "path" {
+			paths = append(paths, path.Clean(e.Value))
+		}
+	}
+	return paths, nil
+}
+
+// gitSubmodules returns the submodules of the git repository containing dir.
+// Only the index entries at paths of submodules configured in .gitmodules are read.
+func gitSubmodules(dir string) ([]submoduleCheckout, error) {
+	top, err := gitOutput(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	paths, err := gitSubmodulePaths(top)
+	if err != nil || len(paths) == 0 {
+		return nil, err
+	}
+	// Submodules are index entries with gitlink mode, e.g., "160000 <revision> 0\t<path>".
+	stage, err := gitOutput(top, append([]string{"--literal-pathspecs", "ls-files", "--stage", "-z", "--"}, paths...)...)
+	if err != nil {
+		return nil, err
+	}
+	var subs []submoduleCheckout
+	for _, line := range strings.Split(stage, "\x00") {
+		if !strings.HasPrefix(line, "160000 ") {
+			continue
+		}
+		meta, subPath, ok := strings.Cut(line, "\t")
+		fields := strings.Fields(meta)
+		if !ok || len(fields) != 3 {
+			return nil, fmt.Errorf("git ls-files: unexpected line %q", line)
+		}
+		sub := submoduleCheckout{
+			Dir:       filepath.Join(top, filepath.FromSlash(subPath)),
+			Path:      subPath,
+			Submodule: Submodule{RecordedRevision: fields[1]},
+		}
+		if _, err := os.Stat(filepath.Join(sub.Dir, ".git")); err == nil {
+			sub.Initialized = true
+			sub.Revision, err = gitOutput(sub.Dir, "rev-parse", "HEAD")
+			if err != nil {
+				return nil, err
+			}
+		}
+		subs = append(subs, sub)
+	}
+	return subs, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestGitSubmodules(t *testing.T) {
+	dir := newGitFixture(t)
+	// A repository added to the index without being configured in .gitmodules isn't a submodule.
+	embedded := filepath.Join(dir, "embedded")
+	git(t, dir, "init", "--quiet", embedded)
+	git(t, embedded, "commit", "--quiet", "--allow-empty", "--message=Initial commit.")
+	git(t, dir, "-c", "advice.addEmbeddedRepo=false", "add", "embedded")
+
+	execGit, nativeGit := newGitBackends(t)
+	for name, b := range map[string]backend{"exec": execGit, "native": nativeGit} {
+		subs, err := b.Submodules(dir)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if len(subs) != 1 || subs[0].Path != "sub" || !subs[0].Initialized {
+			t.Errorf("%s: got submodules %+v, want initialized submodule at sub", name, subs)
+		}
+	}
+}
+
+// TestHeadContainsUnfetched checks that a submodule behind a remote revision
+// that isn't fetched yet isn't reported as an error.
+func TestHeadContainsUnfetched(t *testing.T) {
+	setGitTestEnv(t)
+	dir := filepath.Join(t.TempDir(), "sub")
+	git(t, filepath.Dir(dir), "init", "--quiet", dir)
+	git(t, dir, "commit", "--quiet", "--allow-empty", "--message=Initial commit.")
+	execGit, _ := newGitBackends(t)
+	if c, err := execGit.HeadContains(dir, testRevision, "master"); err != nil || c {
+		t.Errorf("HeadContains: got %v, %v; want false, nil", c, err)
+	}
+	head, err := gitOutput(dir, "rev-parse", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err := execGit.HeadRemoteContains(dir, head, "refs/remotes/origin/master"); err != nil || c {
+		t.Errorf("HeadRemoteContains without a remote-tracking branch: got %v, %v; want false, nil", c, err)
+	}
+}
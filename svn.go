@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shurcooL/vcsstate"
+)
+
+// svn implements vcsstate.VCS for Subversion working copies.
+//
+// Revisions are the revision numbers of the last change to the working copy root.
+// Subversion history is linear, so containment is a comparison of revision numbers.
+// The remote is the same path in the repository the working copy was checked out from.
+type svn struct{}
+
+func (svn) Status(dir string) (string, error) {
+	out, err := vcsOutput(dir, "svn", "status", "--ignore-externals")
+	if err != nil {
+		return "", err
+	}
+	return svnStatusWithoutExternals(out), nil
+}
+
+// svnStatusWithoutExternals returns svn status output without the lines of externals,
+// which are listed with an "X" in the first column even if they're ignored.
+func svnStatusWithoutExternals(status string) string {
+	var lines []string
+	for _, line := range strings.Split(status, "\n") {
+		if line != "" && !strings.HasPrefix(line, "X") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (svn) Branch(dir string) (string, error) {
+	relURL, err := svnInfo(dir, "relative-url", false)
+	if err != nil {
+		return "", err
+	}
+	return svnBranch(relURL), nil
+}
+
+// svnBranch returns the branch of a working copy at relURL, like "^/branches/feature/pkg",
+// following the conventional trunk, branches and tags repository layout.
+// Repositories without that layout have a single line of development, which is reported as trunk.
+func svnBranch(relURL string) string {
+	elems := strings.Split(strings.TrimPrefix(relURL, "^/"), "/")
+	for i, elem := range elems {
+		switch {
+		case elem == "trunk":
+			return "trunk"
+		case (elem == "branches" || elem == "tags") && i+1 < len(elems):
+			return elems[i+1]
+		}
+	}
+	return "trunk"
+}
+
+func (svn) LocalRevision(dir string, defaultBranch string) (string, error) {
+	return svnInfo(dir, "last-changed-revision", false)
+}
+
+// Stash always reports no stash. Subversion shelving is experimental,
+// and its commands changed between releases, so it's not supported.
+func (svn) Stash(dir string) (string, error) {
+	return "", nil
+}
+
+func (s svn) Contains(dir string, revision string, defaultBranch string) (bool, error) {
+	local, err := s.LocalRevision(dir, defaultBranch)
+	if err != nil {
+		return false, err
+	}
+	return svnRevisionAtLeast(local, revision)
+}
+
+func (svn) RemoteContains(dir string, revision string, defaultBranch string) (bool, error) {
+	remote, err := svnInfo(dir, "last-changed-revision", true)
+	if err != nil {
+		return false, err
+	}
+	return svnRevisionAtLeast(remote, revision)
+}
+
+// svnRevisionAtLeast reports whether revision number a is at least revision number b.
+func svnRevisionAtLeast(a, b string) (bool, error) {
+	an, err := strconv.ParseInt(a, 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("invalid svn revision: %v", err)
+	}
+	bn, err := strconv.ParseInt(b, 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("invalid svn revision: %v", err)
+	}
+	return an >= bn, nil
+}
+
+// RemoteURL returns the URL the working copy was checked out from.
+func (svn) RemoteURL(dir string) (string, error) {
+	return svnInfo(dir, "url", false)
+}
+
+// RemoteBranchAndRevision returns trunk as the default branch, and the revision
+// of the last change to the path the working copy was checked out from.
+func (svn) RemoteBranchAndRevision(dir string) (branch string, revision string, err error) {
+	revision, err = svnInfo(dir, "last-changed-revision", true)
+	if err != nil && svnNotFound(err) {
+		return "", "", vcsstate.NotFoundError{Err: err}
+	} else if err != nil {
+		return "", "", err
+	}
+	return "trunk", revision, nil
+}
+
+// svnNotFound reports whether err from svn means that the repository or path doesn't exist.
+func svnNotFound(err error) bool {
+	for _, code := range []string{
+		"E170000", // Illegal repository URL, or URL doesn't exist.
+		"E160013", // Path not found.
+		"E175013", // Access forbidden, which servers also use to hide private repositories.
+	} {
+		if strings.Contains(err.Error(), code) {
+			return true
+		}
+	}
+	return false
+}
+
+func (svn) CachedRemoteDefaultBranch() (string, error) {
+	return "trunk", nil
+}
+
+func (svn) NoRemoteDefaultBranch() string {
+	return "trunk"
+}
+
+// svnInfo returns item of svn info about the root of the working copy containing dir,
+// or about the same path in the repository if remote is true. Extra args are passed to svn info.
+func svnInfo(dir, item string, remote bool, args ...string) (string, error) {
+	root, err := vcsOutput(dir, "svn", "info", "--show-item", "wc-root")
+	if err != nil {
+		return "", err
+	}
+	target := root
+	if remote {
+		target, err = vcsOutput(root, "svn", "info", "--show-item", "url", root)
+		if err != nil {
+			return "", err
+		}
+	}
+	return vcsOutput(root, "svn", append(append([]string{"info", "--show-item", item}, args...), target)...)
+}
+
+// svnCommitTime returns the time of the last change to the working copy root containing dir
+// as of revision rev, or as of the checked out revision if rev is empty.
+func svnCommitTime(dir, rev string) (time.Time, error) {
+	var args []string
+	if rev != "" {
+		args = []string{"--revision", rev}
+	}
+	date, err := svnInfo(dir, "last-changed-date", false, args...)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, date)
+}
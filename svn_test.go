@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestSvnStatusWithoutExternals(t *testing.T) {
+	tests := []struct {
+		status string
+		want   string
+	}{
+		{"", ""},
+		{"X       vendor/lib\n", ""},
+		{"M       a.go\nX       vendor/lib\n?       b.go", "M       a.go\n?       b.go"},
+	}
+	for _, tc := range tests {
+		if got := svnStatusWithoutExternals(tc.status); got != tc.want {
+			t.Errorf("%q: got %q, want %q", tc.status, got, tc.want)
+		}
+	}
+}
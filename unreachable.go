@@ -0,0 +1,63 @@
+package main
+
+import "strings"
+
+// UnreachableReason is the category of reason why a remote is unreachable.
+type UnreachableReason string
+
+const (
+	HostKeyUnknown    UnreachableReason = "host key unknown"
+	DNSFailure        UnreachableReason = "DNS failure"
+	TLSFailure        UnreachableReason = "TLS failure"
+	AuthRequired      UnreachableReason = "auth required"
+	Timeout           UnreachableReason = "timeout"
+	ConnectionRefused UnreachableReason = "connection refused"
+	UnknownReason     UnreachableReason = "unknown"
+)
+
+// unreachableReasons lists substrings of lowercased error messages that identify each reason.
+// They're checked in order, since some messages match more than one reason.
+var unreachableReasons = []struct {
+	reason   UnreachableReason
+	messages []string
+}{
+	{HostKeyUnknown, []string{"host key verification failed", "host key is known", "remote host identification has changed"}},
+	{DNSFailure, []string{"could not resolve host", "name or service not known", "no such host", "temporary failure in name resolution", "nodename nor servname"}},
+	{TLSFailure, []string{"certificate", "gnutls", "ssl_connect", "ssl routines", "tls handshake"}},
+	{AuthRequired, []string{"authentication failed", "could not read username", "could not read password", "terminal prompts disabled", "permission denied", "invalid username or password", "error: 401", "error: 403"}},
+	{Timeout, []string{"timed out", "timeout"}},
+	{ConnectionRefused, []string{"connection refused", "couldn't connect to server", "failed to connect"}},
+}
+
+// classifyUnreachable classifies err, an error from querying the remote, by its message.
+func classifyUnreachable(err error) UnreachableReason {
+	msg := strings.ToLower(err.Error())
+	for _, r := range unreachableReasons {
+		for _, m := range r.messages {
+			if strings.Contains(msg, m) {
+				return r.reason
+			}
+		}
+	}
+	return UnknownReason
+}
+
+// hint returns a hint for fixing a remote that is unreachable for reason r.
+func (r UnreachableReason) hint() string {
+	switch r {
+	case HostKeyUnknown:
+		return "SSH host key is unknown or changed; check your known_hosts"
+	case DNSFailure:
+		return "host name could not be resolved; check your DNS"
+	case TLSFailure:
+		return "TLS error; check your certificates"
+	case AuthRequired:
+		return "authentication failed or required; check your credentials"
+	case Timeout:
+		return "connection timed out; check your connection"
+	case ConnectionRefused:
+		return "connection refused; check the host and port"
+	default:
+		return "check your connection"
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestClassifyUnreachable(t *testing.T) {
+	tests := []struct {
+		stderr string
+		want   UnreachableReason
+	}{
+		{"Host key verification failed.\nfatal: Could not read from remote repository.", HostKeyUnknown},
+		{"@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@\n@    WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED!     @", HostKeyUnknown},
+		{"fatal: unable to access 'https://example.invalid/repo/': Could not resolve host: example.invalid", DNSFailure},
+		{"ssh: Could not resolve hostname example.invalid: Name or service not known", DNSFailure},
+		{"fatal: unable to access 'https://example.com/repo/': SSL certificate problem: self-signed certificate", TLSFailure},
+		{"fatal: could not read Username for 'https://example.com': terminal prompts disabled", AuthRequired},
+		{"git@example.com: Permission denied (publickey).\nfatal: Could not read from remote repository.", AuthRequired},
+		{"fatal: unable to access 'https://example.com/repo/': Failed to connect to example.com port 443 after 130000 ms: Connection timed out", Timeout},
+		{"ssh: connect to host example.com port 22: Connection refused", ConnectionRefused},
+		{"fatal: unable to access 'https://example.com/repo/': Failed to connect to example.com port 443: Couldn't connect to server", ConnectionRefused},
+		{"fatal: protocol error: bad line length character: HTTP", UnknownReason},
+	}
+	for _, tc := range tests {
+		err := errors.New("git ls-remote: exit status 128: " + tc.stderr)
+		if got := classifyUnreachable(err); got != tc.want {
+			t.Errorf("%q: got %q, want %q", tc.stderr, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/build/constraint"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// vendorDrift checks the vendor directory of the main module in directory modDir
+// against its go.mod file, and the vendored packages against the module versions
+// they were vendored from. It returns descriptions of the differences found,
+// or none if there's no vendor directory.
+//
+// Module versions are read from the module cache, or from module zips in a file://
+// GOPROXY, and verified against go.sum before being compared.
+func vendorDrift(modDir string) ([]string, error) {
+	vendorDir := filepath.Join(modDir, "vendor")
+	if _, err := os.Stat(vendorDir); os.IsNotExist(err) {
+		return nil, nil
+	}
+	gomod, err := readGoMod(filepath.Join(modDir, "go.mod"))
+	if err != nil {
+		return nil, err
+	}
+	vendored, err := readModulesTxt(filepath.Join(vendorDir, "modules.txt"))
+	if os.IsNotExist(err) {
+		return []string{"vendor/modules.txt is missing"}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	sums, err := readGoSum(filepath.Join(modDir, "go.sum"))
+	if err != nil {
+		return nil, err
+	}
+
+	drift := vendorRequirementDrift(gomod, vendored)
+	var errs []string
+	var uncached []string // Modules not in the module cache, reported together.
+	for _, vm := range vendored {
+		if len(vm.Packages) == 0 {
+			continue
+		}
+		d, err := vendoredModuleDrift(modDir, vm, sums)
+		if e, ok := err.(notInModuleCacheError); ok {
+			uncached = append(uncached, e.m.String())
+		} else if err != nil {
+			errs = append(errs, err.Error())
+		}
+		drift = append(drift, d...)
+	}
+	if len(uncached) > 0 {
+		errs = append(errs, fmt.Sprintf("vendored packages of modules not in module cache can't be verified: %s", strings.Join(uncached, ", ")))
+	}
+	if len(errs) > 0 {
+		return drift, errors.New(strings.Join(errs, "\n"))
+	}
+	return drift, nil
+}
+
+// vendorRequirementDrift returns descriptions of how the modules vendored
+// according to vendor/modules.txt differ from the requirements in go.mod.
+func vendorRequirementDrift(gomod *goModFile, vendored []*vendoredModule) []string {
+	var drift []string
+	byPath := make(map[string]*vendoredModule)
+	var annotated bool // Whether requirements are annotated, as they are since Go 1.14.
+	for _, vm := range vendored {
+		if vm.Version != "" || vm.Packages != nil {
+			byPath[vm.Path] = vm
+		}
+		annotated = annotated || vm.Explicit
+	}
+	required := make(map[string]bool)
+	for _, req := range gomod.Require {
+		required[req.Path] = true
+		vm, ok := byPath[req.Path]
+		switch {
+		case !ok:
+			drift = append(drift, fmt.Sprintf("%v is required in go.mod, but isn't vendored", req))
+			continue
+		case vm.Version != req.Version:
+			drift = append(drift, fmt.Sprintf("%v is required in go.mod, but %v is vendored", req, vm.moduleVersion))
+			continue
+		case annotated && !vm.Explicit:
+			drift = append(drift, fmt.Sprintf("%v is required in go.mod, but isn't marked explicit in vendor/modules.txt", req))
+		}
+		want, replaced := gomod.replacement(req)
+		switch {
+		case replaced && vm.Replacement == nil:
+			drift = append(drift, fmt.Sprintf("%v is replaced by %v in go.mod, but isn't replaced in vendor/modules.txt", req, want))
+		case !replaced && vm.Replacement != nil:
+			drift = append(drift, fmt.Sprintf("%v is replaced by %v in vendor/modules.txt, but isn't replaced in go.mod", req, *vm.Replacement))
+		case replaced && *vm.Replacement != want:
+			drift = append(drift, fmt.Sprintf("%v is replaced by %v in go.mod, but by %v in vendor/modules.txt", req, want, *vm.Replacement))
+		}
+	}
+	for _, vm := range vendored {
+		if vm.Explicit && !required[vm.Path] {
+			drift = append(drift, fmt.Sprintf("%v is marked explicit in vendor/modules.txt, but isn't required in go.mod", vm.moduleVersion))
+		}
+	}
+	return drift
+}
+
+// vendoredModuleDrift returns descriptions of how the vendored packages of module vm
+// in the main module in directory modDir differ from the module version they were vendored from.
+func vendoredModuleDrift(modDir string, vm *vendoredModule, sums map[moduleVersion]string) ([]string, error) {
+	src := vm.moduleVersion
+	if vm.Replacement != nil {
+		src = *vm.Replacement
+	}
+	var mf *moduleFiles
+	var err error
+	if src.localDir() {
+		dir := src.Path
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(modDir, filepath.FromSlash(dir))
+		}
+		mf, err = dirModuleFiles(dir)
+	} else {
+		mf, err = verifiedModuleFiles(src, sums)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer mf.close()
+
+	var drift []string
+	for _, pkg := range vm.Packages {
+		rel := strings.TrimPrefix(strings.TrimPrefix(pkg, vm.Path), "/")
+		if rel == "" {
+			rel = "."
+		}
+		vendorPkg := path.Join("vendor", pkg)
+		entries, err := os.ReadDir(filepath.Join(modDir, filepath.FromSlash(vendorPkg)))
+		if os.IsNotExist(err) {
+			drift = append(drift, fmt.Sprintf("%s is missing", vendorPkg))
+			continue
+		} else if err != nil {
+			return drift, err
+		}
+		inVendor := make(map[string]bool)
+		for _, e := range entries {
+			if !e.Type().IsRegular() {
+				continue
+			}
+			inVendor[e.Name()] = true
+			name := path.Join(rel, e.Name())
+			if _, ok := mf.open[name]; !ok {
+				drift = append(drift, fmt.Sprintf("%s/%s isn't in %v", vendorPkg, e.Name(), src))
+				continue
+			}
+			b, err := mf.read(name)
+			if err != nil {
+				return drift, err
+			}
+			if same, err := sameContents(filepath.Join(modDir, filepath.FromSlash(vendorPkg), e.Name()), b); err != nil {
+				return drift, err
+			} else if !same {
+				drift = append(drift, fmt.Sprintf("%s/%s differs from %v", vendorPkg, e.Name(), src))
+			}
+		}
+		for _, name := range mf.names() {
+			if path.Dir(name) != rel || inVendor[path.Base(name)] || !vendoredSourceFile(name) {
+				continue
+			}
+			b, err := mf.read(name)
+			if err != nil {
+				return drift, err
+			}
+			if !buildIgnored(name, b) {
+				drift = append(drift, fmt.Sprintf("%s/%s is missing, but is in %v", vendorPkg, path.Base(name), src))
+			}
+		}
+	}
+	return drift, nil
+}
+
+// verifiedModuleFiles returns the files of module m from the module cache, or from
+// a module zip in a file:// GOPROXY, after verifying them against go.sum hashes in sums.
+func verifiedModuleFiles(m moduleVersion, sums map[moduleVersion]string) (*moduleFiles, error) {
+	sum, ok := sums[m]
+	if !ok {
+		return nil, fmt.Errorf("%v: no hash in go.sum to verify vendored packages against", m)
+	}
+	var mf *moduleFiles
+	if dir, err := moduleCacheDir(m); err != nil {
+		return nil, err
+	} else if _, err := os.Stat(dir); err == nil {
+		mf, err = dirModuleFiles(dir)
+		if err != nil {
+			return nil, err
+		}
+	} else if zip, ok := fileProxyZip(m); ok {
+		mf, err = zipModuleFiles(zip, m)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, notInModuleCacheError{m}
+	}
+	got, err := mf.hash1(m)
+	if err != nil {
+		mf.close()
+		return nil, err
+	}
+	if got != sum {
+		mf.close()
+		return nil, fmt.Errorf("%v: hash %s of module files doesn't match go.sum hash %s", m, got, sum)
+	}
+	return mf, nil
+}
+
+// notInModuleCacheError is returned by verifiedModuleFiles when module m
+// is neither in the module cache nor in a file:// GOPROXY.
+type notInModuleCacheError struct{ m moduleVersion }
+
+func (e notInModuleCacheError) Error() string {
+	return fmt.Sprintf("%v: not in module cache, so vendored packages can't be verified", e.m)
+}
+
+// fileProxyZip returns the path of the zip of module m in the first file:// GOPROXY
+// that has it, and reports whether there's one.
+func fileProxyZip(m moduleVersion) (string, bool) {
+	path, err := escapeModulePath(m.Path)
+	if err != nil {
+		return "", false
+	}
+	version, err := escapeModulePath(m.Version)
+	if err != nil {
+		return "", false
+	}
+	for _, proxy := range strings.FieldsFunc(os.Getenv("GOPROXY"), func(r rune) bool { return r == ',' || r == '|' }) {
+		if !strings.HasPrefix(proxy, "file://") {
+			continue
+		}
+		zip := filepath.Join(filepath.FromSlash(strings.TrimPrefix(proxy, "file://")), filepath.FromSlash(path), "@v", version+".zip")
+		if _, err := os.Stat(zip); err == nil {
+			return zip, true
+		}
+	}
+	return "", false
+}
+
+// vendoredSourceFile reports whether go mod vendor copies the file at name
+// of a vendored package. It copies every file but tests, and go.mod and go.sum files
+// since Go 1.17, unless it's Go source excluded by an "ignore" build constraint.
+func vendoredSourceFile(name string) bool {
+	base := path.Base(name)
+	return !strings.HasSuffix(base, "_test.go") && base != "go.mod" && base != "go.sum"
+}
+
+// buildIgnored reports whether the file at name with contents src is Go source
+// whose build constraints exclude it from all builds, so go mod vendor doesn't copy it.
+// Like the go command, it treats every build tag other than "ignore" as both set and unset,
+// so that a tag and its negation are both satisfied, and only "ignore" excludes a file.
+func buildIgnored(name string, src []byte) bool {
+	if !strings.HasSuffix(name, ".go") {
+		return false
+	}
+	var goBuild constraint.Expr
+	var plusBuild []constraint.Expr
+	for _, line := range bytes.Split(src, []byte("\n")) {
+		line := string(bytes.TrimSpace(line))
+		if strings.HasPrefix(line, "package ") {
+			break
+		}
+		if constraint.IsGoBuild(line) {
+			if x, err := constraint.Parse(line); err == nil {
+				goBuild = x
+			}
+		} else if constraint.IsPlusBuild(line) {
+			if x, err := constraint.Parse(line); err == nil {
+				plusBuild = append(plusBuild, x)
+			}
+		}
+	}
+	if goBuild != nil {
+		// //go:build lines take precedence over // +build lines.
+		return !matchAnyTags(goBuild, true)
+	}
+	for _, x := range plusBuild {
+		if !matchAnyTags(x, true) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAnyTags reports whether build constraint x can be satisfied by some set of tags
+// that doesn't include "ignore", like cmd/go/internal/imports does with AnyTags.
+// Tags other than "ignore" match with polarity prefer, which is flipped under negation,
+// so both "linux" and "!linux" are satisfied, but "ignore" isn't.
+func matchAnyTags(x constraint.Expr, prefer bool) bool {
+	switch x := x.(type) {
+	case *constraint.TagExpr:
+		return x.Tag != "ignore" && prefer
+	case *constraint.NotExpr:
+		return !matchAnyTags(x.X, !prefer)
+	case *constraint.AndExpr:
+		return matchAnyTags(x.X, prefer) && matchAnyTags(x.Y, prefer)
+	case *constraint.OrExpr:
+		return matchAnyTags(x.X, prefer) || matchAnyTags(x.Y, prefer)
+	default:
+		return false
+	}
+}
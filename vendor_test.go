@@ -0,0 +1,190 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// depModuleFiles are the files of module example.com/dep at version v1.0.0.
+var depModuleFiles = map[string]string{
+	"go.mod":         "module example.com/dep\n\ngo 1.19\n",
+	"dep.go":         "package dep\n",
+	"dep_test.go":    "package dep\n",
+	"gen.go":         "//go:build ignore\n\npackage main\n",
+	"gen_old.go":     "// +build ignore,linux\n\npackage main\n",
+	"tagged.go":      "//go:build !ignore && cgo\n\npackage dep\n",
+	"notlinux.go":    "//go:build !linux\n\npackage dep\n",
+	"dep_amd64.s":    "// Assembly.\n",
+	"data.txt":       "Embedded data.\n",
+	"sub/sub.go":     "package sub\n",
+	"unused/used.go": "package unused\n",
+}
+
+// newModuleProxy creates a file:// module proxy serving module example.com/dep
+// at version v1.0.0 with depModuleFiles, and returns its URL.
+func newModuleProxy(t testing.TB) string {
+	proxy := t.TempDir()
+	dir := filepath.Join(proxy, "example.com", "dep", "@v")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	goMod := depModuleFiles["go.mod"]
+	writeFile(t, filepath.Join(dir, "list"), "v1.0.0\n")
+	writeFile(t, filepath.Join(dir, "v1.0.0.info"), `{"Version":"v1.0.0","Time":"2020-01-01T00:00:00Z"}`)
+	writeFile(t, filepath.Join(dir, "v1.0.0.mod"), goMod)
+	f, err := os.Create(filepath.Join(dir, "v1.0.0.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, content := range depModuleFiles {
+		w, err := zw.Create("example.com/dep@v1.0.0/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return "file://" + filepath.ToSlash(proxy)
+}
+
+func TestVendorDrift(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not found:", err)
+	}
+	t.Setenv("GO111MODULE", "on")
+	t.Setenv("GOFLAGS", "-modcacherw")
+	t.Setenv("GOPROXY", newModuleProxy(t))
+	t.Setenv("GOSUMDB", "off")
+	t.Setenv("GOWORK", "off")
+	t.Setenv("GOMODCACHE", t.TempDir())
+
+	mod := t.TempDir()
+	writeFile(t, filepath.Join(mod, "go.mod"), "module example.com/main\n\ngo 1.19\n\nrequire example.com/dep v1.0.0\n")
+	writeFile(t, filepath.Join(mod, "main.go"), "package main\n\nimport (\n\t_ \"example.com/dep\"\n\t_ \"example.com/dep/sub\"\n)\n\nfunc main() {}\n")
+	goCmd := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("go", args...)
+		cmd.Dir = mod
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("go %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	goCmd("mod", "tidy")
+	goCmd("mod", "vendor")
+
+	check := func(name string, want []string) {
+		t.Helper()
+		got, err := vendorDrift(mod)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got drift %q, want %q", name, got, want)
+		}
+	}
+	check("vendored", nil)
+
+	// Module files are verified against go.sum, whether they're in the module cache or not.
+	goCmd("clean", "-modcache")
+	check("vendored from proxy", nil)
+
+	writeFile(t, filepath.Join(mod, "vendor", "example.com", "dep", "dep.go"), "package dep // Modified.\n")
+	writeFile(t, filepath.Join(mod, "vendor", "example.com", "dep", "extra.go"), "package dep\n")
+	for _, name := range []string{"sub/sub.go", "dep_amd64.s", "data.txt", "tagged.go", "notlinux.go"} {
+		if err := os.Remove(filepath.Join(mod, "vendor", "example.com", "dep", filepath.FromSlash(name))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check("modified", []string{
+		"vendor/example.com/dep/dep.go differs from example.com/dep@v1.0.0",
+		"vendor/example.com/dep/extra.go isn't in example.com/dep@v1.0.0",
+		"vendor/example.com/dep/data.txt is missing, but is in example.com/dep@v1.0.0",
+		"vendor/example.com/dep/dep_amd64.s is missing, but is in example.com/dep@v1.0.0",
+		"vendor/example.com/dep/notlinux.go is missing, but is in example.com/dep@v1.0.0",
+		"vendor/example.com/dep/tagged.go is missing, but is in example.com/dep@v1.0.0",
+		"vendor/example.com/dep/sub/sub.go is missing, but is in example.com/dep@v1.0.0",
+	})
+
+	writeFile(t, filepath.Join(mod, "go.mod"), "module example.com/main\n\ngo 1.19\n\nrequire (\n\texample.com/dep v1.1.0\n\texample.com/other v0.1.0 // indirect\n)\n")
+	got, _ := vendorDrift(mod)
+	want := []string{
+		"example.com/dep@v1.1.0 is required in go.mod, but example.com/dep@v1.0.0 is vendored",
+		"example.com/other@v0.1.0 is required in go.mod, but isn't vendored",
+	}
+	if len(got) < len(want) || !reflect.DeepEqual(got[:len(want)], want) {
+		t.Errorf("go.mod changed: got drift %q, want it to start with %q", got, want)
+	}
+
+	// Module files that don't match go.sum aren't trusted.
+	writeFile(t, filepath.Join(mod, "go.sum"), "example.com/dep v1.0.0 h1:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=\n")
+	if _, err := vendorDrift(mod); err == nil || !strings.Contains(err.Error(), "doesn't match go.sum hash") {
+		t.Errorf("tampered go.sum: got error %v, want hash mismatch", err)
+	}
+
+	// Without the module cache or a proxy to verify against, that's reported once.
+	t.Setenv("GOPROXY", "off")
+	if _, err := vendorDrift(mod); err == nil || strings.Count(err.Error(), "module cache") != 1 {
+		t.Errorf("not in module cache: got error %v, want it reported once", err)
+	}
+}
+
+func TestParseGoMod(t *testing.T) {
+	const data = `// Comment.
+module "example.com/main"
+
+go 1.19
+
+require example.com/a v1.0.0
+require (
+	example.com/b v0.1.0 // indirect
+	// Comment.
+	example.com/c v2.0.0+incompatible
+)
+
+replace example.com/a => ../a
+
+replace (
+	example.com/b v0.1.0 => example.com/fork/b v0.1.1
+)
+
+exclude example.com/d v1.0.0
+`
+	f, err := parseGoMod(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &goModFile{
+		Module: "example.com/main",
+		Require: []moduleVersion{
+			{"example.com/a", "v1.0.0"},
+			{"example.com/b", "v0.1.0"},
+			{"example.com/c", "v2.0.0+incompatible"},
+		},
+		Replace: []goModReplace{
+			{Old: moduleVersion{Path: "example.com/a"}, New: moduleVersion{Path: "../a"}},
+			{Old: moduleVersion{"example.com/b", "v0.1.0"}, New: moduleVersion{"example.com/fork/b", "v0.1.1"}},
+		},
+	}
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("got %+v, want %+v", f, want)
+	}
+	if r, ok := f.replacement(moduleVersion{"example.com/a", "v1.0.0"}); !ok || !r.localDir() {
+		t.Errorf("replacement of example.com/a: got %v, %v; want local directory ../a", r, ok)
+	}
+	if _, ok := f.replacement(moduleVersion{"example.com/b", "v0.2.0"}); ok {
+		t.Error("example.com/b@v0.2.0 is replaced, want it not to be")
+	}
+}
@@ -3,7 +3,7 @@ package main
 import (
 	"fmt"
 	"go/build"
-	"log"
+	"path/filepath"
 	"strings"
 	"sync"
 
@@ -23,8 +23,36 @@ type workspace struct {
 	shouldShow RepoFilter
 	presenter  RepoPresenter
 
+	// repoRootForImportPath is used to determine the repository URL from import path.
+	repoRootForImportPath func(importPath string, verbose bool) (*vcs.RepoRoot, error)
+
 	reposMu sync.Mutex
 	repos   map[string]*Repo // Map key is the import path corresponding to the root of the repository or Go package.
+
+	// repoDirs and nestedDirs are the root directories of repositories added on their own,
+	// and of repositories nested under another one, like submodules. Each repository is
+	// reported only the first way it's found. If nil, nested repos aren't deduplicated.
+	repoDirs   map[string]bool
+	nestedDirs map[string]bool
+
+	// moduleRepos are the repositories of modules, held back from unique until
+	// all packages are read, so that they record every module of theirs.
+	moduleRepos []*Repo
+
+	// roots indexes discovered repository roots, so that packages of known repositories
+	// are skipped without locating them on disk. If nil, every package is located.
+	roots *repoRoots
+
+	// orphans finds orphaned directories, so that packages not under VCS are grouped
+	// by the topmost orphaned directory they're in. If nil, each package is reported.
+	orphans *orphanDirs
+
+	// nestings caches which repositories are nested in others without being ignored.
+	// If nil, each repository is queried without caching.
+	nestings *nestings
+
+	listedMu sync.Mutex
+	listed   map[string]*listedPackage // Packages listed by go list -json, by import path.
 }
 
 func NewWorkspace(shouldShow RepoFilter, presenter RepoPresenter) *workspace {
@@ -38,7 +66,15 @@ func NewWorkspace(shouldShow RepoFilter, presenter RepoPresenter) *workspace {
 		shouldShow: shouldShow,
 		presenter:  presenter,
 
-		repos: make(map[string]*Repo),
+		repoRootForImportPath: vcs.RepoRootForImportPath,
+
+		repos:      make(map[string]*Repo),
+		repoDirs:   make(map[string]bool),
+		nestedDirs: make(map[string]bool),
+		roots:      newRepoRoots(),
+		orphans:    newOrphanDirs(),
+		nestings:   newNestings(),
+		listed:     make(map[string]*listedPackage),
 	}
 
 	{
@@ -49,6 +85,7 @@ func NewWorkspace(shouldShow RepoFilter, presenter RepoPresenter) *workspace {
 		}
 		go func() {
 			wg.Wait()
+			w.sendModuleRepos()
 			close(w.unique)
 		}()
 	}
@@ -83,9 +120,17 @@ func NewWorkspace(shouldShow RepoFilter, presenter RepoPresenter) *workspace {
 func (w *workspace) uniqueWorker(wg *sync.WaitGroup) {
 	defer wg.Done()
 	for importPath := range w.ImportPaths {
+		if w.roots != nil {
+			if root, ok := w.roots.lookup(importPath); ok {
+				// Package of an already discovered repo, maybe of another of its modules.
+				w.addListedModule(importPath, root)
+				continue
+			}
+		}
+
 		// Determine repo root.
 		// This is potentially somewhat slow.
-		bpkg, err := build.Import(importPath, wd, build.FindOnly|build.IgnoreVendor)
+		bpkg, mod, err := w.importPackage(importPath)
 		if err != nil {
 			w.Errors <- err
 			continue
@@ -94,65 +139,127 @@ func (w *workspace) uniqueWorker(wg *sync.WaitGroup) {
 			// gostatus has no support for printing status of packages in GOROOT, so skip those.
 			continue
 		}
+		if mod != nil {
+			if !mod.cached() {
+				w.addModulePackage(bpkg.Dir, mod.module())
+			}
+			// Dependencies in the module cache aren't checkouts, so skip those.
+			continue
+		}
 		vcsCmd, root, err := vcs.FromDir(bpkg.Dir, bpkg.SrcRoot)
 		if err != nil {
 			// Go package not under VCS.
-			var pkg *Repo
-			w.reposMu.Lock()
-			if _, ok := w.repos[bpkg.ImportPath]; !ok {
-				pkg = &Repo{
-					Path: bpkg.Dir,
-					Root: bpkg.ImportPath,
+			dir, importPath := bpkg.Dir, bpkg.ImportPath
+			if w.orphans != nil && bpkg.SrcRoot != "" {
+				// Report the whole orphaned tree once, rather than each package in it.
+				dir = w.orphans.top(bpkg.Dir, bpkg.SrcRoot)
+				if rel, err := filepath.Rel(bpkg.SrcRoot, dir); err == nil {
+					importPath = filepath.ToSlash(rel)
+				} else {
+					dir = bpkg.Dir
 				}
-				w.repos[bpkg.ImportPath] = pkg
-			}
-			w.reposMu.Unlock()
-
-			// If new package, send off to next stage.
-			if pkg != nil {
-				w.unique <- pkg
 			}
+			w.addOrphanDir(dir, importPath)
 			continue
 		}
-		vcs, err := vcsstate.NewVCS(vcsCmd)
-		if err != nil {
+		rootDir := filepath.Join(bpkg.SrcRoot, filepath.FromSlash(root))
+		if w.roots != nil {
+			w.roots.add(root, rootDir)
+		}
+		repo := &Repo{
+			Path: bpkg.Dir,
+			Root: root,
+		}
+		if vcs, err := newBackend(vcsCmd); err == nil {
+			repo.vcs = vcs
+		} else {
 			// Repository not supported by vcsstate.
-			var pkg *Repo
-			w.reposMu.Lock()
-			if _, ok := w.repos[root]; !ok {
-				pkg = &Repo{
-					Path:     bpkg.Dir,
-					Root:     root,
-					vcsError: fmt.Errorf("%v not supported by vcsstate: %v", vcsCmd.Name, err),
-				}
-				w.repos[root] = pkg
-			}
-			w.reposMu.Unlock()
+			repo.vcsError = fmt.Errorf("%v not supported by vcsstate: %v", vcsCmd.Name, err)
+		}
+		w.addUnique(repo, rootDir)
+	}
+}
 
-			// If new package, display an error and send off to next stage.
-			if pkg != nil {
-				w.unique <- pkg
-			}
-			continue
+// addUnique sends repo off to the next stage, unless a repo with the same Root was already added,
+// or the repository at directory rootDir was already found nested under another one.
+// rootDir is empty for directories not under VCS.
+//
+// Repos of modules are held until all packages are read instead, and sent by sendModuleRepos.
+// If such a repo was already added, repo's modules are recorded in it.
+func (w *workspace) addUnique(repo *Repo, rootDir string) {
+	w.reposMu.Lock()
+	existing, ok := w.repos[repo.Root]
+	ok = ok || (rootDir != "" && w.nestedDirs[rootDir])
+	if ok && existing != nil && existing.Modules != nil {
+		for _, mod := range repo.Modules {
+			existing.addModule(mod)
+		}
+	}
+	if !ok {
+		w.repos[repo.Root] = repo
+		if w.repoDirs != nil && rootDir != "" {
+			w.repoDirs[rootDir] = true
 		}
+		if repo.Modules != nil {
+			w.moduleRepos = append(w.moduleRepos, repo)
+		}
+	}
+	w.reposMu.Unlock()
+	if !ok && repo.Modules == nil {
+		w.unique <- repo
+	}
+}
 
-		var repo *Repo
-		w.reposMu.Lock()
-		if _, ok := w.repos[root]; !ok {
-			repo = &Repo{
-				Path: bpkg.Dir,
-				Root: root,
-				vcs:  vcs,
+// addNested returns the repos out of nested that weren't already added on their own,
+// and records them as nested, so that they won't be. Only repositories under VCS
+// can be added on their own, so others are always kept. Replacements in a repository
+// added on its own are kept too, with that repository covering their state.
+func (w *workspace) addNested(nested []*Repo) []*Repo {
+	if w.nestedDirs == nil {
+		return nested
+	}
+	w.reposMu.Lock()
+	defer w.reposMu.Unlock()
+	var kept []*Repo
+	for _, r := range nested {
+		if r.vcs != nil {
+			dir := r.Path
+			if root, _, ok := repoRootDir(dir); ok {
+				dir = root
+			}
+			switch {
+			case !w.repoDirs[dir]:
+				w.nestedDirs[dir] = true
+			case r.Replacement != nil:
+				// Its state is shown for the repository it's in, but its module path is still checked.
+				r.Replacement.InRepo = true
+				r.vcs = nil
+			default:
+				continue
 			}
-			w.repos[root] = repo
 		}
-		w.reposMu.Unlock()
+		kept = append(kept, r)
+	}
+	return kept
+}
 
-		// If new repo, send off to next stage.
-		if repo != nil {
-			w.unique <- repo
-		}
+// importPackage locates the Go package with import path importPath. Packages listed
+// by go list -json aren't located again, and their module is returned if in module mode.
+func (w *workspace) importPackage(importPath string) (*build.Package, *listedModule, error) {
+	w.listedMu.Lock()
+	p, ok := w.listed[importPath]
+	w.listedMu.Unlock()
+	if !ok {
+		bpkg, err := build.Import(importPath, wd, build.FindOnly|build.IgnoreVendor)
+		return bpkg, nil, err
 	}
+	return &build.Package{
+		Dir:        p.Dir,
+		ImportPath: p.ImportPath,
+		Root:       p.Root,
+		SrcRoot:    p.srcRoot(),
+		Goroot:     p.Goroot || p.Standard,
+	}, p.Module, nil
 }
 
 // processFilterWorker computes repository local and remote state, and filters with shouldShow.
@@ -169,23 +276,96 @@ func (w *workspace) processFilterWorker(wg *sync.WaitGroup) {
 	}
 }
 
-func (*workspace) computeVCSState(r *Repo) {
+func (w *workspace) computeVCSState(r *Repo) {
 	if r.vcs == nil {
-		// Go package not under VCS.
+		// Go package or orphaned directory not under VCS. Replacements
+		// of a main module not under VCS can still be missing or mismatched.
+		w.computeReplacements(r)
 		return
 	}
 
 	if s, err := r.vcs.Status(r.Path); err == nil {
 		r.Local.Status = s
+		r.Local.Changes = parseChanges(r.vcs.Type(), s)
+	} else {
+		r.addError("Status", err)
+	}
+	if op, err := r.vcs.InProgressOperation(r.Path); err == nil {
+		r.Local.Operation = op
+	} else {
+		r.addError("InProgressOperation", err)
 	}
 	if b, err := r.vcs.Branch(r.Path); err == nil {
 		r.Local.Branch = b
+	} else {
+		r.addError("Branch", err)
+	}
+	if r.Worktree != nil {
+		// Linked worktrees share stash and remote with their repository, so they're not computed again.
+		// The remote revision may be that of the worktree's upstream though, so its commit time is.
+		if r.Local.RemoteURL != "" {
+			if t, err := r.vcs.RemoteCommitTime(r.Path, r.Remote.Revision, r.Remote.Branch); err == nil {
+				r.Remote.CommitTime = t
+			} else {
+				r.addError("RemoteCommitTime", err)
+			}
+		}
+		w.computeCheckedOutState(r)
+		return
+	}
+	if r.Submodule == nil {
+		// Submodules are tracked by their superproject.
+		nestedIn := unignoredNesting
+		if w.nestings != nil {
+			nestedIn = w.nestings.unignoredNesting
+		}
+		if parent, err := nestedIn(r.Path); err == nil {
+			r.Local.NestedIn = parent
+		} else {
+			r.addError("NestedIn", err)
+		}
+	}
+	mains := r.mainModules()
+	for _, mod := range mains {
+		addError := r.addError
+		if len(mains) > 1 {
+			// Tell which module each error is about.
+			addError = func(op string, err error) { r.addError(op, fmt.Errorf("%v: %w", mod.Path, err)) }
+		}
+		// Drift found before an error is reported too.
+		drift, err := vendorDrift(mod.Dir)
+		r.Local.VendorDrift = append(r.Local.VendorDrift, drift...)
+		if err != nil {
+			addError("VendorDrift", err)
+		}
+		drift, err = checkoutDrift(mod.Dir)
+		r.Local.CheckoutDrift = append(r.Local.CheckoutDrift, drift...)
+		if err != nil {
+			addError("CheckoutDrift", err)
+		}
+		drift, err = pseudoVersionDrift(mod.Dir)
+		r.Local.PseudoVersionDrift = append(r.Local.PseudoVersionDrift, drift...)
+		if err != nil {
+			addError("PseudoVersionDrift", err)
+		}
 	}
 	if s, err := r.vcs.Stash(r.Path); err == nil {
 		r.Local.Stash = s
+	} else {
+		r.addError("Stash", err)
+	}
+	if stashes, err := r.vcs.StashList(r.Path); err == nil {
+		r.Local.Stashes = stashes
+	} else {
+		r.addError("StashList", err)
 	}
 	if remote, err := r.vcs.RemoteURL(r.Path); err == nil {
 		r.Local.RemoteURL = remote
+	} else if err != vcsstate.ErrNoRemote {
+		r.addError("RemoteURL", err)
+	}
+	if importPath := importPathFromRemoteURL(r.Local.RemoteURL); r.rootInferred && importPath != "" {
+		r.Root = importPath
 	}
 	if b, rev, remoteError := r.vcs.RemoteBranchAndRevision(r.Path); remoteError == nil {
 		r.Remote.Branch = b
@@ -196,32 +376,149 @@ func (*workspace) computeVCSState(r *Repo) {
 		r.Remote.NotFound = notFoundError
 		r.Remote.Branch = r.vcs.NoRemoteDefaultBranch()
 	} else if remoteError != nil {
+		r.addError("RemoteBranchAndRevision", remoteError)
+		r.Remote.Unreachable = classifyUnreachable(remoteError)
 		if b, err := r.vcs.CachedRemoteDefaultBranch(); err == nil {
 			r.Remote.Branch = b
 		} else {
-			log.Printf("%v: %v\n", r.Root, remoteError)
+			r.addError("CachedRemoteDefaultBranch", err)
 			r.Remote.Branch = r.vcs.NoRemoteDefaultBranch() // It's a better fallback than empty string.
 		}
 	}
-	if rev, err := r.vcs.LocalRevision(r.Path, r.Remote.Branch); err == nil {
+	if r.Local.RemoteURL != "" {
+		// Without a remote, there's nothing to fetch from, and no remote commits.
+		if t, err := r.vcs.FetchTime(r.Path); err == nil {
+			r.Local.FetchTime = t
+		} else {
+			r.addError("FetchTime", err)
+		}
+		if t, err := r.vcs.RemoteCommitTime(r.Path, r.Remote.Revision, r.Remote.Branch); err == nil {
+			r.Remote.CommitTime = t
+		} else {
+			r.addError("RemoteCommitTime", err)
+		}
+	}
+	w.computeCheckedOutState(r)
+	if r.Submodule != nil || r.Replacement != nil || r.rootInferred {
+		// The remote URL of a submodule is set by its superproject, a replacement is often a fork,
+		// and an inferred import path comes from the remote URL, so none can be verified against import path.
+		r.Remote.RepoURL = r.Local.RemoteURL
+	} else if rr, err := w.repoRootForImportPath(r.Root, false); err == nil {
+		r.Remote.RepoURL = rr.Repo
+	} else {
+		r.addError("RepoRootForImportPath", err)
+	}
+	if subs, err := submodules(r); err == nil {
+		subs = w.addNested(subs)
+		for _, sub := range subs {
+			if sub.vcs != nil {
+				w.computeVCSState(sub)
+			}
+		}
+		r.Submodules = subs
+	} else {
+		r.addError("Submodules", err)
+	}
+	if r.Submodule == nil {
+		if wts, err := worktrees(r); err == nil {
+			wts = w.addNested(wts)
+			for _, wt := range wts {
+				if wt.vcs != nil {
+					w.computeVCSState(wt)
+				}
+			}
+			r.Worktrees = wts
+		} else {
+			r.addError("Worktrees", err)
+		}
+	}
+	w.computeReplacements(r)
+}
+
+// computeReplacements finds the replacement directories of the main modules of r,
+// and computes the state of the ones that are repositories of their own.
+func (w *workspace) computeReplacements(r *Repo) {
+	replaced := make(map[string]bool) // Replacement directories, since several modules may replace with the same one.
+	for _, mod := range r.mainModules() {
+		reps, err := replacements(mod)
+		if err != nil {
+			r.addError("Replacements", err)
+			continue
+		}
+		for _, rep := range w.addNested(reps) {
+			if replaced[rep.Path] {
+				continue
+			}
+			replaced[rep.Path] = true
+			if rep.vcs != nil {
+				w.computeVCSState(rep)
+			}
+			r.Replacements = append(r.Replacements, rep)
+		}
+	}
+}
+
+// computeCheckedOutState computes local revision state of r relative to its remote,
+// whose state must already be computed.
+func (*workspace) computeCheckedOutState(r *Repo) {
+	contains, remoteContains := r.vcs.Contains, r.vcs.RemoteContains
+	if rev, ok := r.checkedOutRevision(); ok {
+		r.Local.Revision = rev
+		contains = r.vcs.HeadContains
+		remoteContains = func(dir, revision, defaultBranch string) (bool, error) {
+			remoteRef := "refs/remotes/origin/" + defaultBranch
+			if r.Worktree != nil && r.Worktree.UpstreamRef != "" {
+				// Worktrees are compared to the upstream of their branch.
+				remoteRef = r.Worktree.UpstreamRef
+			}
+			return r.vcs.HeadRemoteContains(dir, revision, remoteRef)
+		}
+	} else if rev, err := r.vcs.LocalRevision(r.Path, r.Remote.Branch); err == nil {
 		r.Local.Revision = rev
+	} else {
+		r.addError("LocalRevision", err)
+	}
+	if t, err := r.vcs.CommitTime(r.Path, ""); err == nil {
+		r.Local.CommitTime = t
+	} else {
+		r.addError("CommitTime", err)
+	}
+	if shallow, err := r.vcs.Shallow(r.Path); err == nil {
+		r.Local.Shallow = shallow
+	} else {
+		r.addError("Shallow", err)
+	}
+	if filter, err := r.vcs.PartialCloneFilter(r.Path); err == nil {
+		r.Local.PartialCloneFilter = filter
+	} else {
+		r.addError("PartialCloneFilter", err)
+	}
+	if incompleteClone(r) != "" {
+		// Containment can't be determined reliably when history is incomplete,
+		// so presenters fall back to comparing revisions for equality.
+		return
 	}
 	if r.Remote.Revision != "" {
-		if c, err := r.vcs.Contains(r.Path, r.Remote.Revision, r.Remote.Branch); err == nil {
+		if c, err := contains(r.Path, r.Remote.Revision, r.Remote.Branch); err == nil {
 			r.Local.ContainsRemoteRevision = c
+		} else {
+			r.addError("Contains", err)
 		}
 	}
 	if r.Local.Revision != "" {
-		if c, err := r.vcs.RemoteContains(r.Path, r.Local.Revision, r.Remote.Branch); err == nil {
+		if c, err := remoteContains(r.Path, r.Local.Revision, r.Remote.Branch); err == nil {
 			r.Remote.ContainsLocalRevision = c
 		} else if strings.Contains(err.Error(), "not implemented") && r.Local.Revision != r.Remote.Revision && r.Remote.Revision != "" {
 			// Fall back to using r.Local.ContainsRemoteRevision to deduct information.
 			// Assume that if local contains remote revision, then remote doesn't, and vice versa.
 			r.Remote.ContainsLocalRevision = !r.Local.ContainsRemoteRevision
+		} else {
+			r.addError("RemoteContains", err)
 		}
 	}
-	if rr, err := vcs.RepoRootForImportPath(r.Root, false); err == nil {
-		r.Remote.RepoURL = rr.Repo
+	if r.Local.Revision != r.Remote.Revision && r.Local.ContainsRemoteRevision && r.Remote.ContainsLocalRevision {
+		r.Inconsistency = fmt.Sprintf("local revision %v and remote revision %v contain each other, yet they differ;\n"+
+			"this can happen when the remote was force-pushed or refs were replaced", r.Local.Revision, r.Remote.Revision)
 	}
 }
 
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/shurcooL/vcsstate"
+	"golang.org/x/tools/go/vcs"
+)
+
+// newTestWorkspace returns a workspace for computing VCS state in tests,
+// where all import paths resolve to the https://example.com/repo repository.
+func newTestWorkspace() *workspace {
+	return &workspace{
+		repoRootForImportPath: func(importPath string, _ bool) (*vcs.RepoRoot, error) {
+			return &vcs.RepoRoot{Repo: "https://example.com/repo", Root: importPath}, nil
+		},
+	}
+}
+
+// upToDate returns a fake backend of a clean repository that is up to date with its remote.
+func upToDate() *fakeBackend {
+	return &fakeBackend{
+		branch:         "master",
+		remoteURL:      "https://example.com/repo",
+		remoteBranch:   "master",
+		remoteRevision: "rev1",
+		localRevision:  "rev1",
+		contains:       true,
+		remoteContains: true,
+	}
+}
+
+func TestComputeVCSState(t *testing.T) {
+	defer func(stale time.Duration) { *staleFlag = stale }(*staleFlag)
+	*staleFlag = 90 * 24 * time.Hour
+
+	tests := []struct {
+		name          string
+		repo          *Repo              // If nil, a repo with vcs is used.
+		vcs           func(*fakeBackend) // Modifies upToDate backend.
+		wantCompact   string
+		wantPorcelain string
+	}{
+		{
+			name:          "up to date",
+			vcs:           func(*fakeBackend) {},
+			wantCompact:   "     example.com/repo/...",
+			wantPorcelain: "     example.com/repo/...",
+		},
+		{
+			name:          "not under version control",
+			repo:          &Repo{Path: "/repo", Root: "example.com/repo"},
+			wantCompact:   "???? example.com/repo",
+			wantPorcelain: "???? example.com/repo\n\t? Not under version control",
+		},
+		{
+			name:          "unsupported version control",
+			repo:          &Repo{Path: "/repo", Root: "example.com/repo", vcsError: errors.New("svn not supported by vcsstate")},
+			wantCompact:   "???? example.com/repo/...",
+			wantPorcelain: "???? example.com/repo/...\n\t? Unsupported version control: svn not supported by vcsstate",
+		},
+		{
+			name: "import path inferred from remote URL",
+			repo: func() *Repo {
+				b := upToDate()
+				b.remoteURL = "git@example.com:user/docs.git"
+				return &Repo{Path: "/src/docs", Root: "/src/docs", rootInferred: true, vcs: b}
+			}(),
+			wantCompact:   "     example.com/user/docs/...",
+			wantPorcelain: "     example.com/user/docs/...",
+		},
+		{
+			name:          "non-default branch",
+			vcs:           func(f *fakeBackend) { f.branch = "feature" },
+			wantCompact:   "b    example.com/repo/...",
+			wantPorcelain: "b    example.com/repo/...\n\tb Non-default branch checked out",
+		},
+		{
+			name:          "uncommitted changes",
+			vcs:           func(f *fakeBackend) { f.status = " M a.go\n?? b.go\n" },
+			wantCompact:   " *   example.com/repo/...",
+			wantPorcelain: " *   example.com/repo/...\n\t* Uncommited changes in working dir (1 modified, 1 untracked)",
+		},
+		{
+			name: "operation in progress",
+			vcs: func(f *fakeBackend) {
+				f.operation = "merge"
+				f.status = "UU a.go\n"
+			},
+			wantCompact:   " @   example.com/repo/...",
+			wantPorcelain: " @   example.com/repo/...\n\t@ Operation in progress: merge\n\t* Uncommited changes in working dir (1 conflicted)",
+		},
+		{
+			name: "update available",
+			vcs: func(f *fakeBackend) {
+				f.localRevision, f.contains, f.remoteContains = "rev0", false, true
+			},
+			wantCompact:   "  +  example.com/repo/...",
+			wantPorcelain: "  +  example.com/repo/...\n\t+ Update available",
+		},
+		{
+			name: "local revision ahead",
+			vcs: func(f *fakeBackend) {
+				f.localRevision, f.contains, f.remoteContains = "rev2", true, false
+			},
+			wantCompact:   "  -  example.com/repo/...",
+			wantPorcelain: "  -  example.com/repo/...\n\t- Local revision is ahead of remote revision",
+		},
+		{
+			name: "diverged",
+			vcs: func(f *fakeBackend) {
+				f.localRevision, f.contains, f.remoteContains = "rev2", false, false
+			},
+			wantCompact:   "  ±  example.com/repo/...",
+			wantPorcelain: "  ±  example.com/repo/...\n\t± Update available; local revision is ahead of remote revision",
+		},
+		{
+			name: "inconsistent",
+			vcs: func(f *fakeBackend) {
+				f.localRevision, f.contains, f.remoteContains = "rev2", true, true
+			},
+			wantCompact: "  %  example.com/repo/...",
+			wantPorcelain: "  %  example.com/repo/...\n\t% Inconsistent revision state:" +
+				"\n\t\tlocal revision rev2 and remote revision rev1 contain each other, yet they differ;" +
+				"\n\t\tthis can happen when the remote was force-pushed or refs were replaced",
+		},
+		{
+			name: "no remote",
+			vcs: func(f *fakeBackend) {
+				f.remoteURL, f.remoteErr = "", vcsstate.ErrNoRemote
+			},
+			wantCompact:   "  !  example.com/repo/...",
+			wantPorcelain: "  !  example.com/repo/...\n\t! No remote",
+		},
+		{
+			name: "remote not found",
+			vcs: func(f *fakeBackend) {
+				f.remoteErr = vcsstate.NotFoundError{Err: errors.New("repository not found")}
+			},
+			wantCompact: "  /  example.com/repo/...",
+			wantPorcelain: "  /  example.com/repo/...\n\t/ Remote repository not found (was it deleted? made private?):" +
+				"\n" + indent(vcsstate.NotFoundError{Err: errors.New("repository not found")}.Error(), 2),
+		},
+		{
+			name: "unreachable remote",
+			vcs: func(f *fakeBackend) {
+				f.remoteErr = errors.New("fatal: unable to access 'https://example.com/repo/': Could not resolve host: example.com")
+				f.cachedRemoteDefaultBranch = "master"
+			},
+			wantCompact:   "  ?  example.com/repo/...",
+			wantPorcelain: "  ?  example.com/repo/...\n\t? Unreachable remote (host name could not be resolved; check your DNS)",
+		},
+		{
+			name:        "remote URL doesn't match",
+			vcs:         func(f *fakeBackend) { f.remoteURL = "https://example.com/fork" },
+			wantCompact: "  #  example.com/repo/...",
+			wantPorcelain: "  #  example.com/repo/...\n\t# Remote URL doesn't match repo URL inferred from import path:" +
+				"\n\t\t  (actual) https://example.com/fork" +
+				"\n\t\t(expected) https://example.com/repo",
+		},
+		{
+			name: "shallow clone",
+			vcs: func(f *fakeBackend) {
+				f.shallow, f.localRevision = true, "rev0"
+			},
+			wantCompact:   "  ~  example.com/repo/...",
+			wantPorcelain: "  ~  example.com/repo/...\n\t~ History is incomplete (shallow clone); local revision differs from remote revision",
+		},
+		{
+			name:          "partial clone",
+			vcs:           func(f *fakeBackend) { f.partialCloneFilter = "blob:none" },
+			wantCompact:   "  ~  example.com/repo/...",
+			wantPorcelain: "  ~  example.com/repo/...\n\t~ History is incomplete (partial clone)",
+		},
+		{
+			name: "stale remote",
+			vcs: func(f *fakeBackend) {
+				f.remoteCommitTime = time.Now().Add(-100 * 24 * time.Hour)
+			},
+			wantCompact:   "  z  example.com/repo/...",
+			wantPorcelain: "  z  example.com/repo/...\n\tz Remote has had no commits in 100 days",
+		},
+		{
+			name: "stale fetch",
+			vcs: func(f *fakeBackend) {
+				f.fetchTime = time.Now().Add(-200 * 24 * time.Hour)
+			},
+			wantCompact:   "  z  example.com/repo/...",
+			wantPorcelain: "  z  example.com/repo/...\n\tz Local clone hasn't been fetched in 200 days",
+		},
+		{
+			name: "stash",
+			vcs: func(f *fakeBackend) {
+				f.stash = "stash@{0}: WIP on master: rev1 Commit message\n"
+				f.stashes = []StashEntry{{Name: "stash@{0}", Message: "rev1 Commit message", Branch: "master", Time: time.Now().Add(-3 * 24 * time.Hour)}}
+			},
+			wantCompact:   "   $ example.com/repo/...",
+			wantPorcelain: "   $ example.com/repo/...\n\t$ Stash exists (1 entry, newest 3 days ago)",
+		},
+		{
+			name: "submodules",
+			vcs: func(f *fakeBackend) {
+				f.submodules = map[string][]submoduleCheckout{"/repo": {
+					{Dir: "/repo/a", Path: "a", Submodule: Submodule{Initialized: true, RecordedRevision: "rev1", Revision: "rev1"}},
+					{Dir: "/repo/b", Path: "b", Submodule: Submodule{Initialized: true, RecordedRevision: "rev0", Revision: "rev1"}},
+					{Dir: "/repo/c", Path: "c", Submodule: Submodule{RecordedRevision: "rev1"}},
+				}}
+			},
+			wantCompact: "     example.com/repo/..." +
+				"\n\t     example.com/repo/a/..." +
+				"\n\tr    example.com/repo/b/..." +
+				"\n\tu    example.com/repo/c/...",
+			wantPorcelain: "     example.com/repo/..." +
+				"\n\t     example.com/repo/a/..." +
+				"\n\tr    example.com/repo/b/..." +
+				"\n\t\tr Submodule revision differs from the one recorded by superproject" +
+				"\n\tu    example.com/repo/c/..." +
+				"\n\t\tu Submodule not initialized",
+		},
+		{
+			name: "worktrees",
+			vcs: func(f *fakeBackend) {
+				f.worktrees = map[string][]worktreeCheckout{"/repo": {
+					{Dir: "/wt", Worktree: Worktree{Revision: "rev1"}},
+					{Dir: "/gone", Worktree: Worktree{Revision: "rev1", Missing: true}},
+				}}
+			},
+			wantCompact: "     example.com/repo/..." +
+				"\n\t     worktree /wt (master)" +
+				"\n\tx    worktree /gone",
+			wantPorcelain: "     example.com/repo/..." +
+				"\n\t     worktree /wt (master)" +
+				"\n\tx    worktree /gone" +
+				"\n\t\tx Worktree directory is missing (see git worktree prune)",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := tc.repo
+			if r == nil {
+				b := upToDate()
+				tc.vcs(b)
+				r = &Repo{Path: "/repo", Root: "example.com/repo", vcs: b}
+			}
+			newTestWorkspace().computeVCSState(r)
+			if got, want := WithNestedRepos(CompactPresenter)(r), tc.wantCompact; got != want {
+				t.Errorf("CompactPresenter:\ngot:\n%s\nwant:\n%s", got, want)
+			}
+			if got, want := WithNestedRepos(PorcelainPresenter)(r), tc.wantPorcelain; got != want {
+				t.Errorf("PorcelainPresenter:\ngot:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestComputeVCSStateRemoteFallback(t *testing.T) {
+	remoteErr := errors.New("exit status 128")
+	tests := []struct {
+		name                      string
+		cachedRemoteDefaultBranch string
+		wantRemoteBranch          string
+		wantErrorOps              []string
+	}{
+		{
+			name:                      "cached remote default branch",
+			cachedRemoteDefaultBranch: "main",
+			wantRemoteBranch:          "main",
+			wantErrorOps:              []string{"RemoteBranchAndRevision"},
+		},
+		{
+			name:             "no cached remote default branch",
+			wantRemoteBranch: "master", // Falls back to NoRemoteDefaultBranch.
+			wantErrorOps:     []string{"RemoteBranchAndRevision", "CachedRemoteDefaultBranch"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			b := upToDate()
+			b.remoteErr = remoteErr
+			b.cachedRemoteDefaultBranch = tc.cachedRemoteDefaultBranch
+			r := &Repo{Path: "/repo", Root: "example.com/repo", vcs: b}
+			newTestWorkspace().computeVCSState(r)
+			if got, want := r.Remote.Branch, tc.wantRemoteBranch; got != want {
+				t.Errorf("got Remote.Branch %q, want %q", got, want)
+			}
+			if got, want := r.Remote.Unreachable, UnknownReason; got != want {
+				t.Errorf("got Remote.Unreachable %q, want %q", got, want)
+			}
+			var ops []string
+			for _, err := range r.Errors {
+				ops = append(ops, err.(*OpError).Op)
+			}
+			if got, want := ops, tc.wantErrorOps; !reflect.DeepEqual(got, want) {
+				t.Errorf("got error ops %q, want %q", got, want)
+			}
+			if got, want := r.Errors[0].Error(), fmt.Sprintf("RemoteBranchAndRevision: %v", remoteErr); got != want {
+				t.Errorf("got first error %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestNestedReposReportedOnce(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	newWorkspace := func() *workspace {
+		return &workspace{
+			unique:     make(chan *Repo, 1),
+			repos:      make(map[string]*Repo),
+			repoDirs:   make(map[string]bool),
+			nestedDirs: make(map[string]bool),
+		}
+	}
+	nested := func() []*Repo {
+		return []*Repo{{Path: dir, Root: "example.com/super/sub", Submodule: &Submodule{Initialized: true}, vcs: &fakeBackend{}}}
+	}
+
+	// Found on its own first.
+	w := newWorkspace()
+	w.addUnique(&Repo{Path: filepath.Join(dir, "pkg"), Root: "example.com/super/sub", vcs: &fakeBackend{}}, dir)
+	if got := len(w.unique); got != 1 {
+		t.Errorf("found on its own first: got %d repos added on their own, want 1", got)
+	}
+	if got := w.addNested(nested()); len(got) != 0 {
+		t.Errorf("found on its own first: got %d nested repos, want none", len(got))
+	}
+
+	// Found nested first.
+	w = newWorkspace()
+	if got := w.addNested(nested()); len(got) != 1 {
+		t.Errorf("found nested first: got %d nested repos, want 1", len(got))
+	}
+	w.addUnique(&Repo{Path: filepath.Join(dir, "pkg"), Root: "example.com/super/sub", vcs: &fakeBackend{}}, dir)
+	if got := len(w.unique); got != 0 {
+		t.Errorf("found nested first: got %d repos added on their own, want none", got)
+	}
+}
+
+func TestReplacementAddedOnItsOwn(t *testing.T) {
+	setGitTestEnv(t)
+	dir := t.TempDir()
+	mod, dep := filepath.Join(dir, "main"), filepath.Join(dir, "dep")
+	if err := os.Mkdir(mod, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(mod, "go.mod"), "module example.com/main\n\ngo 1.19\n\nreplace example.com/dep => ../dep\n")
+	git(t, dir, "init", "--quiet", "dep")
+	writeFile(t, filepath.Join(dep, "go.mod"), "module example.com/other\n")
+
+	w := &workspace{
+		unique:     make(chan *Repo, 1),
+		repos:      make(map[string]*Repo),
+		repoDirs:   make(map[string]bool),
+		nestedDirs: make(map[string]bool),
+	}
+	// The replaced module's packages are part of the input, so its repository is added on its own.
+	w.addUnique(&Repo{Path: dep, Root: "example.com/dep", vcs: &fakeBackend{}}, dep)
+	r := &Repo{Path: mod, Root: "example.com/main", Modules: []*Module{{Path: "example.com/main", Dir: mod, Main: true}}}
+	w.computeReplacements(r)
+
+	if len(r.Replacements) != 1 {
+		t.Fatalf("got %d replacements, want 1", len(r.Replacements))
+	}
+	rep := r.Replacements[0]
+	if !rep.Replacement.InRepo || rep.vcs != nil {
+		t.Errorf("got in repo %v, under VCS %v; want in repo without a backend of its own", rep.Replacement.InRepo, rep.vcs != nil)
+	}
+	if got, want := CompactPresenter(rep), "m    replace example.com/dep => ../dep"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestComputeVCSStateNotApplicable(t *testing.T) {
+	setGitTestEnv(t)
+	dir := filepath.Join(t.TempDir(), "repo")
+	git(t, filepath.Dir(dir), "init", "--quiet", "--initial-branch=master", dir)
+	git(t, dir, "commit", "--quiet", "--allow-empty", "--message=Initial commit.")
+	git(t, dir, "checkout", "--quiet", "--detach")
+	b, err := newBackend(vcs.ByCmd("git"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A repository with detached HEAD, that has no remote and was never fetched,
+	// has nothing to report for those, rather than errors.
+	r := &Repo{Path: dir, Root: "example.com/repo", vcs: b}
+	newTestWorkspace().computeVCSState(r)
+	if len(r.Errors) != 0 {
+		t.Errorf("got errors %v, want none", r.Errors)
+	}
+	git(t, dir, "remote", "add", "origin", filepath.Join(filepath.Dir(dir), "missing.git"))
+	r = &Repo{Path: dir, Root: "example.com/repo", vcs: b}
+	newTestWorkspace().computeVCSState(r)
+	for _, err := range r.Errors {
+		if op := err.(*OpError).Op; op != "RemoteBranchAndRevision" && op != "CachedRemoteDefaultBranch" {
+			t.Errorf("remote added: got error %v, want none other than the remote being unreachable", err)
+		}
+	}
+}
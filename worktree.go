@@ -0,0 +1,164 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Worktree describes a linked git worktree of a repository.
+type Worktree struct {
+	Revision string // Checked out revision.
+	Upstream string // Upstream of the checked out branch, like "origin/feature", if it has one.
+
+	// UpstreamRef is the full ref name of Upstream, like "refs/remotes/origin/feature".
+	UpstreamRef string `json:",omitempty"`
+	Missing     bool   // Whether the worktree directory was removed while the worktree is still registered.
+}
+
+// worktreeCheckout is a git worktree of a repository.
+type worktreeCheckout struct {
+	Dir              string // Directory of the worktree.
+	UpstreamRevision string // Last fetched revision of the upstream, if there's one.
+	Worktree
+}
+
+// worktrees returns the git worktrees of repository r, other than the one r is in.
+// Linked worktrees share r's backend and remote, and their local state is yet to be computed.
+// Worktrees on a branch with an upstream are compared to it, rather than to the remote default branch.
+func worktrees(r *Repo) ([]*Repo, error) {
+	checkouts, err := r.vcs.Worktrees(r.Path)
+	if err != nil {
+		return nil, err
+	}
+	var wts []*Repo
+	for _, c := range checkouts {
+		worktree := c.Worktree
+		wt := &Repo{
+			Path:     c.Dir,
+			Root:     r.Root,
+			Worktree: &worktree,
+		}
+		if !worktree.Missing {
+			wt.vcs = r.vcs
+		}
+		wt.Local.RemoteURL = r.Local.RemoteURL
+		wt.Local.FetchTime = r.Local.FetchTime
+		wt.Remote.RepoURL = r.Remote.RepoURL
+		wt.Remote.Branch = r.Remote.Branch
+		wt.Remote.Revision = r.Remote.Revision
+		if worktree.Upstream != "" && c.UpstreamRevision != "" {
+			wt.Remote.Revision = c.UpstreamRevision
+		} else {
+			// An upstream that was never fetched can't be compared to, so the default branch is.
+			worktree.UpstreamRef = ""
+		}
+		wts = append(wts, wt)
+	}
+	return wts, nil
+}
+
+// gitWorktrees returns the worktrees of the git repository containing dir,
+// other than the one dir is in.
+func gitWorktrees(dir string) ([]worktreeCheckout, error) {
+	top, err := gitOutput(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	list, err := gitOutput(dir, "worktree", "list", "--porcelain")
+	if err != nil {
+		return nil, err
+	}
+	upstreams, err := gitUpstreams(dir)
+	if err != nil {
+		return nil, err
+	}
+	var wts []worktreeCheckout
+	// Worktrees are separated by blank lines, each starting with a "worktree <path>" line.
+	for _, block := range strings.Split(list, "\n\n") {
+		var (
+			wt   worktreeCheckout
+			bare bool
+		)
+		for _, line := range strings.Split(block, "\n") {
+			switch key, value, _ := strings.Cut(line, " "); key {
+			case "worktree":
+				wt.Dir = filepath.FromSlash(value)
+			case "HEAD":
+				wt.Revision = value
+			case "branch":
+				if u, ok := upstreams[value]; ok {
+					wt.Upstream, wt.UpstreamRef, wt.UpstreamRevision = u.name, u.ref, u.revision
+				}
+			case "bare":
+				bare = true
+			case "prunable":
+				wt.Missing = true
+			}
+		}
+		if wt.Dir == "" || bare || sameDir(wt.Dir, top) {
+			continue
+		}
+		if _, err := os.Stat(wt.Dir); os.IsNotExist(err) {
+			wt.Missing = true
+		}
+		wts = append(wts, wt)
+	}
+	return wts, nil
+}
+
+// gitUpstream is the upstream of a git branch.
+type gitUpstream struct {
+	name     string // Short name, like "origin/feature".
+	ref      string // Full ref name, like "refs/remotes/origin/feature".
+	revision string // Last fetched revision. It's empty if never fetched.
+}
+
+// gitUpstreams returns the upstreams of the branches of the git repository containing dir
+// that have one, keyed by full branch ref name.
+func gitUpstreams(dir string) (map[string]gitUpstream, error) {
+	out, err := gitOutput(dir, "for-each-ref", "--format=%(refname)%00%(upstream)%00%(upstream:short)", "refs/heads")
+	if err != nil {
+		return nil, err
+	}
+	upstreams := make(map[string]gitUpstream)
+	upstreamRefs := make(map[string]string) // Branch ref name -> upstream ref name.
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, "\x00")
+		if len(fields) != 3 || fields[1] == "" {
+			continue
+		}
+		upstreams[fields[0]] = gitUpstream{name: fields[2], ref: fields[1]}
+		upstreamRefs[fields[0]] = fields[1]
+	}
+	if len(upstreamRefs) == 0 {
+		return upstreams, nil
+	}
+	// Remote-tracking upstreams that were never fetched don't exist yet.
+	out, err = gitOutput(dir, "for-each-ref", "--format=%(refname)%00%(objectname)", "refs/heads", "refs/remotes")
+	if err != nil {
+		return nil, err
+	}
+	revisions := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		if ref, rev, ok := strings.Cut(line, "\x00"); ok {
+			revisions[ref] = rev
+		}
+	}
+	for branch, ref := range upstreamRefs {
+		u := upstreams[branch]
+		u.revision = revisions[ref]
+		upstreams[branch] = u
+	}
+	return upstreams, nil
+}
+
+// sameDir reports whether directories a and b are the same, after resolving symlinks.
+func sameDir(a, b string) bool {
+	if a, err := filepath.EvalSymlinks(a); err == nil {
+		if b, err := filepath.EvalSymlinks(b); err == nil {
+			return a == b
+		}
+	}
+	return filepath.Clean(a) == filepath.Clean(b)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGitWorktrees(t *testing.T) {
+	setGitTestEnv(t)
+	tmp := t.TempDir()
+	remote := filepath.Join(tmp, "remote.git")
+	dir := filepath.Join(tmp, "repo")
+	git(t, tmp, "init", "--quiet", "--bare", "--initial-branch=master", remote)
+	git(t, tmp, "clone", "--quiet", remote, dir)
+	git(t, dir, "commit", "--quiet", "--allow-empty", "--message=Initial commit.")
+	git(t, dir, "push", "--quiet", "origin", "master", "master:feature")
+	upstreamRevision, err := gitOutput(dir, "rev-parse", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	git(t, dir, "fetch", "--quiet", "origin")
+	git(t, dir, "worktree", "add", "--quiet", "--track", "-b", "feature", filepath.Join(tmp, "feature"), "origin/feature")
+	git(t, dir, "worktree", "add", "--quiet", "-b", "local", filepath.Join(tmp, "local"))
+	git(t, filepath.Join(tmp, "feature"), "commit", "--quiet", "--allow-empty", "--message=Feature commit.")
+
+	wts, err := gitWorktrees(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wts) != 2 {
+		t.Fatalf("got %d worktrees, want 2", len(wts))
+	}
+	if got := wts[0]; got.Upstream != "origin/feature" || got.UpstreamRevision != upstreamRevision {
+		t.Errorf("feature: got upstream %q at %q, want origin/feature at %q", got.Upstream, got.UpstreamRevision, upstreamRevision)
+	}
+	if got := wts[1]; got.Upstream != "" || got.UpstreamRevision != "" {
+		t.Errorf("local: got upstream %q at %q, want none", got.Upstream, got.UpstreamRevision)
+	}
+}
+
+// newBehindWorktree creates a git repository whose remote has a feature branch with a commit
+// at time recent, which is fetched, and a linked worktree on a feature branch that tracks
+// the remote one, but is behind it. The worktree's revision isn't on the default branch. It returns the repository and worktree directories.
+func newBehindWorktree(t *testing.T, recent time.Time) (dir, worktree string) {
+	setGitTestEnv(t)
+	tmp := t.TempDir()
+	remote := filepath.Join(tmp, "remote.git")
+	dir = filepath.Join(tmp, "repo")
+	other := filepath.Join(tmp, "other")
+	worktree = filepath.Join(tmp, "feature")
+	git(t, tmp, "init", "--quiet", "--bare", "--initial-branch=master", remote)
+	git(t, tmp, "clone", "--quiet", remote, dir)
+	git(t, dir, "commit", "--quiet", "--allow-empty", "--message=Initial commit.")
+	git(t, dir, "push", "--quiet", "origin", "master", "master:feature")
+	t.Setenv("GIT_COMMITTER_DATE", recent.Format(time.RFC3339))
+	git(t, tmp, "clone", "--quiet", "--branch=feature", remote, other)
+	git(t, other, "commit", "--quiet", "--allow-empty", "--message=Feature commit.")
+	git(t, other, "commit", "--quiet", "--allow-empty", "--message=Another feature commit.")
+	git(t, other, "push", "--quiet", "origin", "feature")
+	git(t, dir, "fetch", "--quiet", "origin")
+	git(t, dir, "worktree", "add", "--quiet", "--track", "-b", "feature", worktree, "origin/feature")
+	git(t, worktree, "reset", "--quiet", "--hard", "HEAD~")
+	return dir, worktree
+}
+
+func TestWorktreeRemoteCommitTime(t *testing.T) {
+	recent := time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC)
+	dir, worktree := newBehindWorktree(t, recent)
+	execGit, _ := newGitBackends(t)
+	r := &Repo{Path: dir, Root: "example.com/repo", vcs: execGit}
+	newTestWorkspace().computeVCSState(r)
+	if len(r.Worktrees) != 1 || !sameDir(r.Worktrees[0].Path, worktree) {
+		t.Fatalf("got worktrees %v, want %s", r.Worktrees, worktree)
+	}
+	if got := r.Worktrees[0].Remote.CommitTime; !got.Equal(recent) {
+		t.Errorf("got remote commit time %v, want that of the upstream, %v", got, recent)
+	}
+}
+
+// TestWorktreeBehindUpstream checks that a worktree behind its upstream is reported so,
+// rather than relative to the remote default branch.
+func TestWorktreeBehindUpstream(t *testing.T) {
+	_, worktree := newBehindWorktree(t, time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC))
+	execGit, _ := newGitBackends(t)
+	// The root is inferred, so the remote URL isn't verified against it.
+	r := &Repo{Path: filepath.Join(filepath.Dir(worktree), "repo"), Root: "example.com/repo", rootInferred: true, vcs: execGit}
+	newTestWorkspace().computeVCSState(r)
+	if len(r.Worktrees) != 1 {
+		t.Fatalf("got %d worktrees, want 1", len(r.Worktrees))
+	}
+	wt := r.Worktrees[0]
+	if wt.Local.ContainsRemoteRevision || !wt.Remote.ContainsLocalRevision {
+		t.Errorf("got local contains remote %v, remote contains local %v; want false, true", wt.Local.ContainsRemoteRevision, wt.Remote.ContainsLocalRevision)
+	}
+	// The worktree's branch isn't the default one, so the first column is "b".
+	if got := CompactPresenter(wt)[:4]; got != "b + " {
+		t.Errorf("got compact status %q, want %q", got, "b + ")
+	}
+	for _, err := range wt.Errors {
+		t.Error(err)
+	}
+}